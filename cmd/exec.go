@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jevi061/ops/internal/ops"
+	"github.com/jevi061/ops/internal/shellquote"
+	"github.com/spf13/cobra"
+)
+
+var (
+	execHosts         []string
+	execTags          []string
+	execOpsfile       string
+	execDebug         bool
+	execDryRun        bool
+	execEnvs          []string
+	execCmd           ops.Command
+	execBecomeUser    string
+	execConnectPolicy string
+)
+
+func NewExecCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "exec [SERVER...] [--] COMMAND...",
+		Args:  cobra.MinimumNArgs(1),
+		Short: "Run an ad-hoc command on servers",
+		Long: `Run an ad-hoc command on servers without defining a task, output is prefixed by servers,
+eg: ops exec web-1 'df -h', ops exec --tag web -- uptime`,
+		Run: func(cmd *cobra.Command, args []string) {
+			// the last argument is command unless -- separates servers from command
+			servers, words := args[:len(args)-1], args[len(args)-1:]
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				servers, words = args[:dash], args[dash:]
+			}
+			if len(words) == 0 {
+				fmt.Fprintln(os.Stderr, "No command to run")
+				os.Exit(1)
+			}
+			conf, err := ops.NewOpsfileFromPathAndEnvVars(execOpsfile, execEnvs)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if cmd.Flags().Changed("connect-policy") {
+				if err := conf.SetConnectPolicy(execConnectPolicy); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
+			execCmd.Cmd = commandOf(words)
+			execCmd.BecomeUser = execBecomeUser
+			o := ops.NewOps(conf, ops.WithDebug(execDebug), ops.WithDryRun(execDryRun))
+			if err := o.Exec(&ops.Selector{Hosts: append(servers, execHosts...), Tags: execTags}, &execCmd); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().StringSliceVarP(&execHosts, "hosts", "H", []string{}, "server names, globs or groups to run on, prefix with ! to exclude, eg: web,db-1,!canary")
+	cmd.Flags().StringArrayVarP(&execTags, "tag", "t", []string{}, "server tag expression, repeat to require all, eg: web|api, !canary")
+	cmd.Flags().StringVarP(&execOpsfile, "opsfile", "f", "./Opsfile.yml", "opsfile")
+	cmd.Flags().BoolVarP(&execDebug, "debug", "d", false, "run command in debug mode")
+	cmd.Flags().BoolVarP(&execDryRun, "dry-run", "", false, "print command without running it")
+	cmd.Flags().StringArrayVarP(&execEnvs, "env", "e", []string{}, "run with env vars, eg: USER=root")
+	cmd.Flags().BoolVarP(&execCmd.Parallel, "parallel", "p", false, "run on all servers concurrently")
+	cmd.Flags().StringVarP(&execCmd.Dir, "dir", "", "", "working directory of command")
+	cmd.Flags().BoolVarP(&execCmd.Become, "become", "b", false, "run command through sudo as root")
+	cmd.Flags().StringVarP(&execBecomeUser, "become-user", "", "", "run command through sudo as user, implies become")
+	cmd.Flags().StringVarP(&execConnectPolicy, "connect-policy", "", "", "what to do when servers are unreachable: abort or skip-unreachable, overrides Opsfile")
+	return cmd
+}
+
+// commandOf returns shell command of words, a single word is a command line run by shell as is,
+// eg: 'df -h', multiple words are arguments of a command which are quoted to keep them apart.
+func commandOf(words []string) string {
+	if len(words) == 1 {
+		return words[0]
+	}
+	return shellquote.Join(words...)
+}
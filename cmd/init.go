@@ -12,7 +12,7 @@ func NewInitCmd() *cobra.Command {
 	var opsfile = "./Opsfile.yml"
 	const base = `
 shell: bash
-fail-fast: true	
+fail-fast: true
 servers:
   example:
     host: www.example.com
@@ -34,11 +34,11 @@ tasks:
     command: make test
   upload:
     desc: upload tested project to remote
-    transfer: . -> /app
+    payload: . -> /app
   deploy:
     desc: deploy tested project to remote
     command: make deploy
-    deps:
+    dependencies:
       - prepare
       - build
       - test
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jevi061/ops/internal/ops"
+	"github.com/spf13/cobra"
+)
+
+var (
+	inventoryOpsfile string
+	refreshInventory bool
+)
+
+func NewInventoryCmd() *cobra.Command {
+	boxStyle := table.StyleLight
+	boxStyle.Options = table.OptionsNoBordersAndSeparators
+	boxStyle.Options.SeparateHeader = true
+
+	var inventoryCmd = &cobra.Command{
+		Use:   "inventory",
+		Args:  cobra.MatchAll(cobra.NoArgs),
+		Short: "List servers resolved from inventory",
+		Long:  `List servers resolved from inventory script or file defined in Opsfile`,
+		Run: func(cmd *cobra.Command, args []string) {
+			conf, err := ops.NewOpsfileFromPath(inventoryOpsfile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if conf.Inventory == nil {
+				fmt.Fprintln(os.Stderr, "No inventory defined in", inventoryOpsfile)
+				os.Exit(1)
+			}
+			if err := conf.LoadInventory(refreshInventory); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			tw := table.NewWriter()
+			tw.SetStyle(boxStyle)
+			tw.SetOutputMirror(os.Stdout)
+			tw.AppendHeader(table.Row{"Server", "Host", "Port", "User", "Tags", "Note"})
+			for _, v := range conf.Inventory.Servers() {
+				note := ""
+				if conf.Servers.Names[v.Name] != v {
+					note = "overridden by Opsfile"
+				}
+				tw.AppendRow(table.Row{v.Name, v.Host, v.Port, v.User, strings.Join(v.Tags, ","), note})
+			}
+			tw.Render()
+		},
+	}
+	inventoryCmd.Flags().StringVarP(&inventoryOpsfile, "opsfile", "f", "./Opsfile.yml", "opsfile")
+	inventoryCmd.Flags().BoolVarP(&refreshInventory, "refresh", "r", false, "ignore cached inventory and resolve again")
+	return inventoryCmd
+}
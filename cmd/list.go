@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/jevi061/ops/internal/ops"
@@ -13,6 +14,8 @@ var (
 	conf            string
 	listServersOnly bool
 	listTasksOnly   bool
+	listHosts       []string
+	listTags        []string
 )
 
 func NewListCmd() *cobra.Command {
@@ -40,28 +43,36 @@ func NewListCmd() *cobra.Command {
 			stw := table.NewWriter()
 			stw.SetStyle(boxStyle)
 			stw.SetOutputMirror(os.Stdout)
-			stw.AppendHeader(table.Row{"Server", "Host", "Port", "User"})
-			for k, v := range conf.Servers.Names {
-				stw.AppendRow(table.Row{k, v.Host, v.Port, v.User})
+			stw.AppendHeader(table.Row{"Server", "Host", "Port", "User", "Tags"})
+			// servers of inventory are only resolved when listed
+			if !listTasksOnly {
+				servers, err := conf.Select(&ops.Selector{Hosts: listHosts, Tags: listTags})
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				for _, v := range servers {
+					stw.AppendRow(table.Row{v.Name, v.Host, v.Port, v.User, strings.Join(v.Tags, ",")})
+				}
 			}
 
 			ttw := table.NewWriter()
 			ttw.SetStyle(boxStyle)
 			ttw.SetOutputMirror(os.Stdout)
 			ttw.AppendHeader(table.Row{"Task", "Local", "Desc"})
-			for _, task := range conf.Tasks.Names {
+			for _, task := range conf.OrderedTasks() {
 				ttw.AppendRow(table.Row{task.Name, task.Local, task.Desc})
 			}
 			if listServersOnly {
-				fmt.Fprintln(os.Stdout, serverPrompet)
+				fmt.Fprintf(os.Stdout, "%s\n", serverPrompet)
 				stw.Render()
 			} else if listTasksOnly {
-				fmt.Fprintln(os.Stdout, taskPrompet)
+				fmt.Fprintf(os.Stdout, "%s\n", taskPrompet)
 				ttw.Render()
 			} else {
-				fmt.Fprintln(os.Stdout, serverPrompet)
+				fmt.Fprintf(os.Stdout, "%s\n", serverPrompet)
 				stw.Render()
-				fmt.Fprintln(os.Stdout, taskPrompet)
+				fmt.Fprintf(os.Stdout, "%s\n", taskPrompet)
 				ttw.Render()
 			}
 		},
@@ -70,5 +81,7 @@ func NewListCmd() *cobra.Command {
 	listCmd.PersistentFlags().StringVarP(&conf, "opsfile", "f", "./Opsfile.yml", "opsfile")
 	listCmd.Flags().BoolVarP(&listServersOnly, "server-only", "s", false, "list avaliable servers without list tasks")
 	listCmd.Flags().BoolVarP(&listTasksOnly, "task-only", "t", false, "list avaliable tasks without list servers")
+	listCmd.Flags().StringSliceVarP(&listHosts, "hosts", "H", []string{}, "list servers resolved from names, globs or groups")
+	listCmd.Flags().StringArrayVarP(&listTags, "tag", "", []string{}, "list servers resolved from tag expressions")
 	return listCmd
 }
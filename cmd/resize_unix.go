@@ -0,0 +1,36 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/containerd/console"
+	"golang.org/x/crypto/ssh"
+)
+
+// watchResize changes window size of session when current console is resized, until the
+// returned stop function is called.
+func watchResize(session *ssh.Session, current console.Console) func() {
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-resized:
+				if ws, err := current.Size(); err == nil {
+					session.WindowChange(int(ws.Height), int(ws.Width))
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(resized)
+		close(done)
+	}
+}
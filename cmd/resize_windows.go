@@ -0,0 +1,35 @@
+//go:build windows
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/containerd/console"
+	"golang.org/x/crypto/ssh"
+)
+
+// watchResize changes window size of session when current console is resized, until the
+// returned stop function is called. Windows has no SIGWINCH, so the size is polled.
+func watchResize(session *ssh.Session, current console.Console) func() {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		last, _ := current.Size()
+		for {
+			select {
+			case <-ticker.C:
+				if ws, err := current.Size(); err == nil && ws != last {
+					last = ws
+					session.WindowChange(int(ws.Height), int(ws.Width))
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
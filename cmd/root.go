@@ -21,7 +21,11 @@ func Execute() {
 	rootCmd.AddCommand(NewSShCommand())
 	rootCmd.AddCommand(NewInitCmd())
 	rootCmd.AddCommand(NewRunCmd())
+	rootCmd.AddCommand(NewExecCmd())
 	rootCmd.AddCommand(NewListCmd())
+	rootCmd.AddCommand(NewSchemaCmd())
+	rootCmd.AddCommand(NewInventoryCmd())
+	rootCmd.AddCommand(NewTunnelCmd())
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
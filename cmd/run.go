@@ -9,12 +9,14 @@ import (
 )
 
 var (
-	tag           string
+	hosts         []string
+	tags          []string
 	opsfile       string
 	debug         bool
 	dryRun        bool
 	alwaysConfirm bool
 	envs          []string
+	connectPolicy string
 )
 
 func NewRunCmd() *cobra.Command {
@@ -29,18 +31,26 @@ func NewRunCmd() *cobra.Command {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
+			if cmd.Flags().Changed("connect-policy") {
+				if err := conf.SetConnectPolicy(connectPolicy); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
 			o := ops.NewOps(conf, ops.WithDebug(debug), ops.WithDryRun(dryRun), ops.WithAlwaysConfirm(alwaysConfirm))
-			if err := o.Run(tag, args...); err != nil {
+			if err := o.Run(&ops.Selector{Hosts: hosts, Tags: tags}, args...); err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
 		},
 	}
-	runCmd.Flags().StringVarP(&tag, "tag", "t", "", "server tag")
+	runCmd.Flags().StringSliceVarP(&hosts, "hosts", "H", []string{}, "server names, globs or groups to run on, prefix with ! to exclude, eg: web,db-1,!canary")
+	runCmd.Flags().StringArrayVarP(&tags, "tag", "t", []string{}, "server tag expression, repeat to require all, eg: web|api, !canary")
 	runCmd.Flags().StringVarP(&opsfile, "opsfile", "f", "./Opsfile.yml", "opsfile")
 	runCmd.Flags().BoolVarP(&debug, "debug", "d", false, "run tasks in debug mode")
 	runCmd.Flags().BoolVarP(&dryRun, "dry-run", "", false, "test task without applying changes")
 	runCmd.Flags().BoolVarP(&alwaysConfirm, "", "y", false, "ignore task prompt and always continue with yes")
 	runCmd.Flags().StringArrayVarP(&envs, "env", "e", []string{}, "run with env vars, eg: USER=root")
+	runCmd.Flags().StringVarP(&connectPolicy, "connect-policy", "", "", "what to do when servers are unreachable: abort or skip-unreachable, overrides Opsfile")
 	return runCmd
 }
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jevi061/ops/internal/ops"
+	"github.com/spf13/cobra"
+)
+
+var (
+	schemaOutput string
+)
+
+func NewSchemaCmd() *cobra.Command {
+	var schemaCmd = &cobra.Command{
+		Use:   "schema",
+		Args:  cobra.MatchAll(cobra.NoArgs),
+		Short: "Print JSON Schema of Opsfile",
+		Long:  `Print JSON Schema of Opsfile, which could be used by editors to autocomplete and lint Opsfile.yml`,
+		Run: func(cmd *cobra.Command, args []string) {
+			data, err := ops.Schema()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			data = append(data, '\n')
+			if schemaOutput == "" {
+				os.Stdout.Write(data)
+				return
+			}
+			if err := os.WriteFile(schemaOutput, data, 0644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+	schemaCmd.Flags().StringVarP(&schemaOutput, "output", "o", "", "write schema to file instead of stdout")
+	return schemaCmd
+}
@@ -1,153 +1,228 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
-	"strings"
-	"time"
 
 	"github.com/containerd/console"
+	"github.com/jevi061/ops/internal/connector"
 	"github.com/jevi061/ops/internal/ops"
+	"github.com/jevi061/ops/internal/shellquote"
+	"github.com/jevi061/ops/internal/tunnel"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/term"
 )
 
 var (
-	ofile string
+	ofile      string
+	sshTunnels tunnelFlags
+	sshHosts   []string
+	sshTags    []string
+	sshAgent   bool
+	sshSendEnv []string
+	sshPolicy  string
 )
 
 func NewSShCommand() *cobra.Command {
 	var sshCmd = &cobra.Command{
-		Use:   "ssh SERVER",
-		Args:  cobra.MatchAll(cobra.MaximumNArgs(1), cobra.MinimumNArgs(1)),
+		Use:   "ssh [SERVER] [-- COMMAND...]",
+		Args:  sshArgs,
 		Short: "Open a shell to target remote server",
-		Long:  `Open an interactive shell through ssh to remote server,eg: ops ssh example`,
+		Long: `Open an interactive shell through ssh to remote server,eg: ops ssh example
+Or run a command with a terminal on remote server, eg: ops ssh example -- htop
+Or open a shell running each line on selected servers concurrently, eg: ops ssh --tag web
+Exit code of remote shell or command is exit code of ops`,
 		Run: func(cmd *cobra.Command, args []string) {
-			serverName := args[0]
-			conf, err := ops.NewOpsfileFromPath(ofile)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				os.Exit(1)
+			var command []string
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				args, command = args[:dash], args[dash:]
 			}
-			c, ok := conf.Servers.Names[serverName]
-			if !ok {
-				fmt.Fprintln(os.Stderr, "No server name matched to :", serverName, "in", ofile)
+			cluster := len(sshHosts) > 0 || len(sshTags) > 0
+			if len(args) == 0 && !cluster {
+				fmt.Fprintln(os.Stderr, "Server is required, or select servers by --hosts and --tag")
 				os.Exit(1)
 			}
-			// prefer private key based auth method
-			authMethods := make([]ssh.AuthMethod, 0)
-			if homeDir, err := os.UserHomeDir(); err != nil {
-				fmt.Fprintln(os.Stderr, "find user home dir failed: ", err)
+			if len(args) > 0 && cluster {
+				fmt.Fprintln(os.Stderr, "Server is exclusive with --hosts and --tag")
 				os.Exit(1)
-			} else {
-				var signers []ssh.Signer
-				for _, name := range []string{"id_rsa", "id_ecdsa", "id_ecdsa_sk", "id_ed25519", "id_ed25519_sk", "id_dsa"} {
-					path := filepath.Join(homeDir, ".ssh", name)
-					if _, err := os.Stat(path); !os.IsNotExist(err) {
-						if privateKey, err := os.ReadFile(path); err == nil {
-							if signer, err := ssh.ParsePrivateKey(privateKey); err == nil {
-								signers = append(signers, signer)
-							}
-						}
-					}
+			}
+			if cluster {
+				if len(command) > 0 {
+					fmt.Fprintln(os.Stderr, "Command is not allowed with --hosts and --tag, use ops exec instead")
+					os.Exit(1)
 				}
-				if len(signers) > 0 {
-					authMethods = append(authMethods, ssh.PublicKeys(signers...))
+				if len(sshTunnels.local)+len(sshTunnels.remote)+len(sshTunnels.dynamic) > 0 {
+					fmt.Fprintln(os.Stderr, "Tunnels are not allowed with --hosts and --tag")
+					os.Exit(1)
 				}
-			}
-			if c.Password != "" {
-				authMethods = append(authMethods, ssh.Password(c.Password))
-			}
-			if len(authMethods) <= 0 {
-				fmt.Printf("%s@%s's password: ", c.User, c.Host)
-				if pass, err := term.ReadPassword(int(os.Stdin.Fd())); err != nil {
-					fmt.Fprintf(os.Stderr, "\nread password failed:%s\n", err.Error())
+				conf, err := ops.NewOpsfileFromPath(ofile)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
 					os.Exit(1)
-				} else {
-					fmt.Println("")
-					c.Password = string(pass)
-					authMethods = append(authMethods, ssh.Password(c.Password))
 				}
-
-			}
-			config := &ssh.ClientConfig{
-				User:            c.User,
-				Auth:            authMethods,
-				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-				Timeout:         5 * time.Second,
-			}
-			conn, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", c.Host, c.Port), config)
-			if err != nil {
-				if strings.Contains(err.Error(), "unable to authenticate") && !strings.Contains(err.Error(), "password") {
-					fmt.Printf("%s@%s's password: ", c.User, c.Host)
-					if pass, err := term.ReadPassword(int(os.Stdin.Fd())); err != nil {
-						fmt.Fprintln(os.Stderr, "\nconnect to server :", serverName, "failed:", err)
+				if cmd.Flags().Changed("connect-policy") {
+					if err := conf.SetConnectPolicy(sshPolicy); err != nil {
+						fmt.Fprintln(os.Stderr, err)
 						os.Exit(1)
-					} else {
-						c.Password = string(pass)
-						config.Auth = append(authMethods, ssh.Password(c.Password))
-						conn, err = ssh.Dial("tcp", fmt.Sprintf("%s:%d", c.Host, c.Port), config)
-						if err != nil {
-							fmt.Fprintln(os.Stderr, "\nconnect to server :", serverName, "failed:", err)
-							os.Exit(1)
-						}
 					}
-				} else {
-					fmt.Fprintln(os.Stderr, "\nconnect to server :", serverName, "failed:", err)
+				}
+				if err := ops.NewOps(conf).Cluster(&ops.Selector{Hosts: sshHosts, Tags: sshTags}); err != nil {
+					fmt.Fprintln(os.Stderr, err)
 					os.Exit(1)
 				}
+				return
 			}
-			defer conn.Close()
-			session, err := conn.NewSession()
+			serverName := args[0]
+			specs, err := sshTunnels.specs()
 			if err != nil {
-				fmt.Fprintln(os.Stderr, "open session to :", serverName, "failed:", err)
+				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
-			defer session.Close()
-			session.Stdout = os.Stdout
-			session.Stderr = os.Stderr
-			session.Stdin = os.Stdin
-			modes := ssh.TerminalModes{
-				ssh.ECHO:          1, // enable echoing
-				ssh.ECHOCTL:       0,
-				ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
-				ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
-				ssh.VSTATUS:       1,
-			}
-			current := console.Current()
-			if err := current.SetRaw(); err != nil {
-				fmt.Fprintln(os.Stderr, "make current console in raw mode failed:", err)
+			conf, err := ops.NewOpsfileFromPath(ofile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
-			} else {
-				defer current.Reset()
 			}
-			ws, err := current.Size()
+			c, err := conf.Server(serverName)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, "get current console size failed:", err)
+				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
-			current.Resize(ws)
-
-			term := os.Getenv("TERM")
-			if term == "" {
-				term = "xterm-256color"
-			}
-			if err := session.RequestPty(term, int(ws.Height), int(ws.Width), modes); err != nil {
-				fmt.Fprintln(os.Stderr, "request pty to :", serverName, "failed:", err)
+			sc, err := ops.NewOps(conf).Connect(serverName)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
-
-			if err := session.Shell(); err != nil {
-				fmt.Fprintln(os.Stderr, "open session to :", serverName, "failed:", err)
-				os.Exit(1)
+			defer sc.Close()
+			conn := sc.Client()
+			forwardAgent := sshAgent || c.ForwardAgent
+			if sshAgent && !c.ForwardAgent {
+				if err := connector.ForwardAgent(conn); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
 			}
-			if err := session.Wait(); err != nil {
+			opened := make([]*tunnel.Tunnel, 0, len(specs))
+			for _, spec := range specs {
+				t, err := tunnel.Open(sc, spec, tunnel.WithErrorHandler(func(err error) {
+					// terminal is in raw mode
+					fmt.Fprintf(os.Stderr, "%s\r\n", err)
+				}))
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "open tunnel:", spec, "failed:", err)
+					os.Exit(1)
+				}
+				opened = append(opened, t)
+			}
+			code, err := runSession(conn, commandOf(command), forwardAgent, append(c.SendEnv, sshSendEnv...))
+			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 			}
+			// deferred closes are skipped by os.Exit
+			for _, t := range opened {
+				t.Close()
+			}
+			sc.Close()
+			os.Exit(code)
 		},
 	}
 	sshCmd.PersistentFlags().StringVarP(&ofile, "opsfile", "f", "./Opsfile.yml", "opsfile")
+	sshTunnels.register(sshCmd)
+	sshCmd.Flags().BoolVarP(&sshAgent, "forward-agent", "A", false, "forward local ssh agent to server")
+	sshCmd.Flags().StringArrayVarP(&sshSendEnv, "send-env", "", []string{}, "send local env vars matching names or globs to server, eg: LC_*")
+	sshCmd.Flags().StringSliceVarP(&sshHosts, "hosts", "H", []string{}, "server names, globs or groups to open a shell to together, prefix with ! to exclude")
+	sshCmd.Flags().StringArrayVarP(&sshTags, "tag", "t", []string{}, "server tag expression to open a shell to together, repeat to require all")
+	sshCmd.Flags().StringVarP(&sshPolicy, "connect-policy", "", "", "what to do when servers of --hosts and --tag are unreachable: abort or skip-unreachable, overrides Opsfile")
 	return sshCmd
 }
+
+// sshArgs accepts a server, and command after -- to run on it.
+func sshArgs(cmd *cobra.Command, args []string) error {
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+		if len(args) == dash {
+			return errors.New("command is required after --")
+		}
+		args = args[:dash]
+	}
+	return cobra.MaximumNArgs(1)(cmd, args)
+}
+
+// runSession runs command, or a login shell if command is empty, in a session through conn, it
+// returns exit code of remote. A terminal is requested if stdin is a terminal, whose size
+// follows the local one.
+func runSession(conn *ssh.Client, command string, forwardAgent bool, sendEnv []string) (int, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return 255, fmt.Errorf("open session failed: %w", err)
+	}
+	defer session.Close()
+	if forwardAgent {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			return 255, fmt.Errorf("request agent forwarding failed: %w", err)
+		}
+	}
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+	session.Stdin = os.Stdin
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          1, // enable echoing
+			ssh.ECHOCTL:       0,
+			ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
+			ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+			ssh.VSTATUS:       1,
+		}
+		current := console.Current()
+		if err := current.SetRaw(); err != nil {
+			return 255, fmt.Errorf("make current console in raw mode failed: %w", err)
+		}
+		defer current.Reset()
+		ws, err := current.Size()
+		if err != nil {
+			return 255, fmt.Errorf("get current console size failed: %w", err)
+		}
+		termName := os.Getenv("TERM")
+		if termName == "" {
+			termName = "xterm-256color"
+		}
+		if err := session.RequestPty(termName, int(ws.Height), int(ws.Width), modes); err != nil {
+			return 255, fmt.Errorf("request pty failed: %w", err)
+		}
+		defer watchResize(session, current)()
+	} else if command == "" {
+		return 255, errors.New("stdin is not a terminal, run a command instead: ops ssh SERVER -- COMMAND")
+	}
+	// sent vars are exported ahead of command, or a login shell replacing the one of session
+	exports, err := shellquote.Environ(connector.MatchEnvs(sendEnv))
+	if err != nil {
+		return 255, err
+	}
+	if exports != "" {
+		if command == "" {
+			command = `exec "${SHELL:-/bin/sh}" -l`
+		}
+		command = fmt.Sprintf("export %s; %s", exports, command)
+	}
+	if command == "" {
+		err = session.Shell()
+	} else {
+		err = session.Start(command)
+	}
+	if err != nil {
+		return 255, fmt.Errorf("start session failed: %w", err)
+	}
+	err = session.Wait()
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &exitErr) && exitErr.Signal() == "":
+		return exitErr.ExitStatus(), nil
+	default:
+		// killed by signal or exit status is missing
+		return 255, err
+	}
+}
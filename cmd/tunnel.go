@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jevi061/ops/internal/ops"
+	"github.com/jevi061/ops/internal/tunnel"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tunnelOpsfile string
+	tunnels       tunnelFlags
+)
+
+// tunnelFlags are tunnels of ssh style flags: -L, -R and -D.
+type tunnelFlags struct {
+	local   []string
+	remote  []string
+	dynamic []string
+}
+
+func (f *tunnelFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVarP(&f.local, "local", "L", []string{}, "forward local port to address reachable from server, eg: 5432:localhost:5432")
+	cmd.Flags().StringArrayVarP(&f.remote, "remote", "R", []string{}, "forward port of server to address reachable from local, eg: 8080:localhost:3000")
+	cmd.Flags().StringArrayVarP(&f.dynamic, "dynamic", "D", []string{}, "open SOCKS5 proxy on local port connecting through server, eg: 1080")
+}
+
+// specs parses specs of tunnels.
+func (f *tunnelFlags) specs() ([]tunnel.Spec, error) {
+	specs := make([]tunnel.Spec, 0)
+	kinds := []string{tunnel.Local, tunnel.Remote, tunnel.Dynamic}
+	for i, values := range [][]string{f.local, f.remote, f.dynamic} {
+		for _, v := range values {
+			spec, err := tunnel.Parse(kinds[i], v)
+			if err != nil {
+				return nil, err
+			}
+			specs = append(specs, spec)
+		}
+	}
+	return specs, nil
+}
+
+func NewTunnelCmd() *cobra.Command {
+	var tunnelCmd = &cobra.Command{
+		Use:   "tunnel SERVER",
+		Args:  cobra.ExactArgs(1),
+		Short: "Forward ports through remote server",
+		Long:  `Forward ports through remote server until interrupted, eg: ops tunnel db -L 5432:localhost:5432`,
+		Run: func(cmd *cobra.Command, args []string) {
+			specs, err := tunnels.specs()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if len(specs) == 0 {
+				fmt.Fprintln(os.Stderr, "No tunnel to open, use -L, -R or -D")
+				os.Exit(1)
+			}
+			conf, err := ops.NewOpsfileFromPath(tunnelOpsfile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if err := ops.NewOps(conf).Tunnel(args[0], specs...); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+	tunnelCmd.Flags().StringVarP(&tunnelOpsfile, "opsfile", "f", "./Opsfile.yml", "opsfile")
+	tunnels.register(tunnelCmd)
+	return tunnelCmd
+}
@@ -9,7 +9,9 @@ require (
 	github.com/containerd/console v1.0.4
 	github.com/gookit/color v1.5.4
 	github.com/jedib0t/go-pretty/v6 v6.5.6
+	github.com/klauspost/compress v1.17.4
 	github.com/mattn/go-runewidth v0.0.15
+	github.com/pkg/sftp v1.13.6
 	github.com/rs/xid v1.5.0
 	github.com/spf13/cobra v1.7.0
 	golang.org/x/crypto v0.17.0
@@ -28,6 +30,7 @@ require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/jpillora/sshd-lite v1.7.1 // indirect
 	github.com/karfield/ssh2go v0.0.0-20160427213048-a3cd1ecdb04d // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	github.com/matir/sshdog v0.0.0-20200109212941-94a466579cda // indirect
 	github.com/mattn/go-colorable v0.1.2 // indirect
 	github.com/mattn/go-isatty v0.0.8 // indirect
@@ -0,0 +1,56 @@
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/jevi061/ops/internal/shellquote"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ForwardAgent serves agent requests from remote server through client by local ssh agent,
+// sessions need to request forwarding by agent.RequestAgentForwarding.
+func ForwardAgent(client *ssh.Client) error {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return errors.New("forward agent failed: SSH_AUTH_SOCK is not set")
+	}
+	if conn, err := net.Dial("unix", sock); err != nil {
+		return fmt.Errorf("connect to agent failed: %w", err)
+	} else {
+		conn.Close()
+	}
+	// connect to local agent for each request, so a restarted agent is still reachable
+	if err := agent.ForwardToRemote(client, sock); err != nil {
+		return fmt.Errorf("forward agent failed: %w", err)
+	}
+	return nil
+}
+
+// MatchEnvs returns local env vars whose names are valid and match any of patterns, patterns
+// are names or globs, eg: LC_*. They are assigned in commands sent to server, as sshd accepts
+// few of vars requested by SendEnv of ssh.
+func MatchEnvs(patterns []string) map[string]string {
+	envs := make(map[string]string)
+	if len(patterns) == 0 {
+		return envs
+	}
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if !shellquote.IsName(name) {
+			continue
+		}
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, name); ok {
+				envs[name] = value
+				break
+			}
+		}
+	}
+	return envs
+}
@@ -0,0 +1,50 @@
+package connector
+
+import (
+	"fmt"
+	"sort"
+)
+
+// becomeArgs returns sudo arguments to run command as user, sudo prompts with prompt
+// when password is required.
+func becomeArgs(prompt, user string) []string {
+	args := []string{"sudo", "-p", prompt}
+	if user != "" {
+		args = append(args, "-u", user)
+	}
+	return append(args, "--")
+}
+
+// becomeStdinArgs returns sudo arguments to run command as user without terminal, eg: tasks
+// with input. Sudo reads password from the first line of stdin if stdin is true, cached
+// credentials are ignored then so the password line is never left in input of command.
+// Otherwise sudo fails instead of prompting for password.
+func becomeStdinArgs(user string, stdin bool) []string {
+	args := []string{"sudo", "-n"}
+	if stdin {
+		args = []string{"sudo", "-S", "-k", "-p", ""}
+	}
+	if user != "" {
+		args = append(args, "-u", user)
+	}
+	return append(args, "--")
+}
+
+// envArgs returns env arguments to run command with envs, as sudo resets environments.
+func envArgs(envs map[string]string) []string {
+	args := []string{"env"}
+	names := make([]string, 0, len(envs))
+	for k := range envs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		args = append(args, k+"="+envs[k])
+	}
+	return args
+}
+
+// becomePrompt is the sudo prompt to answer with password automatically.
+func becomePrompt(id string) string {
+	return fmt.Sprintf(`[sudo via ops, id=%s] password:`, id)
+}
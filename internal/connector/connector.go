@@ -19,10 +19,17 @@ type Connector interface {
 	Promet() string
 	SetPromet(string)
 	Host() string
+	// Name is the server name of connector in Opsfile
+	Name() string
 	Signal(os.Signal) error
+	// Output runs cmd through shell in working directory of connector and returns its stdout,
+	// it's used to inspect connector before running tasks
+	Output(cmd string) ([]byte, error)
 }
 
 type RunOptions struct {
 	Debug  bool
 	DryRun bool
+	// Targets is number of connectors the task runs through
+	Targets int
 }
@@ -0,0 +1,220 @@
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// forwardMsg is payload of tcpip-forward and cancel-tcpip-forward requests, see RFC 4254 section 7.1.
+type forwardMsg struct {
+	Addr string
+	Port uint32
+}
+
+// forwardedMsg is payload of forwarded-tcpip channels, see RFC 4254 section 7.2.
+type forwardedMsg struct {
+	Addr       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// remoteForwards routes connections forwarded by server to listeners of remote forwards. Unlike
+// Listen of ssh.Client, bind addresses are sent as they are, so names like localhost are
+// resolved by server instead of locally.
+type remoteForwards struct {
+	client    *ssh.Client
+	mu        sync.Mutex
+	listeners []*remoteListener
+}
+
+func newRemoteForwards(client *ssh.Client) (*remoteForwards, error) {
+	in := client.HandleChannelOpen("forwarded-tcpip")
+	if in == nil {
+		return nil, errors.New("forwarded connections are already handled")
+	}
+	f := &remoteForwards{client: client}
+	go f.handle(in)
+	return f, nil
+}
+
+// listen asks server to listen on addr, port 0 is allocated by server.
+func (f *remoteForwards) listen(addr string) (*remoteListener, error) {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port: %s", p)
+	}
+	msg := forwardMsg{Addr: host, Port: uint32(port)}
+	ok, resp, err := f.client.SendRequest("tcpip-forward", true, ssh.Marshal(&msg))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("tcpip-forward request denied by server")
+	}
+	if msg.Port == 0 {
+		var allocated struct{ Port uint32 }
+		if err := ssh.Unmarshal(resp, &allocated); err != nil {
+			return nil, err
+		}
+		msg.Port = allocated.Port
+	}
+	l := &remoteListener{forwards: f, msg: msg, incoming: make(chan ssh.NewChannel), closed: make(chan struct{})}
+	f.mu.Lock()
+	f.listeners = append(f.listeners, l)
+	f.mu.Unlock()
+	return l, nil
+}
+
+// handle dispatches forwarded connections until connection is closed, which closes all listeners.
+func (f *remoteForwards) handle(in <-chan ssh.NewChannel) {
+	for ch := range in {
+		var msg forwardedMsg
+		if err := ssh.Unmarshal(ch.ExtraData(), &msg); err != nil {
+			ch.Reject(ssh.ConnectionFailed, "could not parse forwarded-tcpip payload: "+err.Error())
+			continue
+		}
+		l := f.lookup(msg.Addr, msg.Port)
+		if l == nil || !l.deliver(ch) {
+			ch.Reject(ssh.Prohibited, "no forward for address")
+		}
+	}
+	f.mu.Lock()
+	listeners := f.listeners
+	f.listeners = nil
+	f.mu.Unlock()
+	for _, l := range listeners {
+		l.once.Do(func() { close(l.closed) })
+	}
+}
+
+// lookup returns listener of forward on addr and port, servers may report addresses other than
+// the requested ones, eg: resolved ones, so forwards are also matched by port only.
+func (f *remoteForwards) lookup(addr string, port uint32) *remoteListener {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var matched *remoteListener
+	for _, l := range f.listeners {
+		if l.msg.Port != port {
+			continue
+		}
+		if l.msg.Addr == addr {
+			return l
+		}
+		if matched == nil {
+			matched = l
+		}
+	}
+	return matched
+}
+
+func (f *remoteForwards) remove(l *remoteListener) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, v := range f.listeners {
+		if v == l {
+			f.listeners = append(f.listeners[:i], f.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// remoteListener is a listener on server, connections accepted are channels forwarded by server.
+type remoteListener struct {
+	forwards *remoteForwards
+	msg      forwardMsg
+	incoming chan ssh.NewChannel
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// deliver hands ch to Accept, it reports false if listener is closed.
+func (l *remoteListener) deliver(ch ssh.NewChannel) bool {
+	select {
+	case l.incoming <- ch:
+		return true
+	case <-l.closed:
+		return false
+	}
+}
+
+func (l *remoteListener) Accept() (net.Conn, error) {
+	select {
+	case ch := <-l.incoming:
+		var msg forwardedMsg
+		ssh.Unmarshal(ch.ExtraData(), &msg)
+		channel, reqs, err := ch.Accept()
+		if err != nil {
+			return nil, err
+		}
+		go ssh.DiscardRequests(reqs)
+		return &channelConn{Channel: channel, local: l.Addr(), remote: forwardAddr{msg.OriginAddr, msg.OriginPort}}, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops forwarding, and asks server to stop listening.
+func (l *remoteListener) Close() error {
+	closed := false
+	l.once.Do(func() {
+		close(l.closed)
+		closed = true
+	})
+	if !closed {
+		return nil
+	}
+	l.forwards.remove(l)
+	ok, _, err := l.forwards.client.SendRequest("cancel-tcpip-forward", true, ssh.Marshal(&l.msg))
+	if err == nil && !ok {
+		err = errors.New("cancel-tcpip-forward request denied by server")
+	}
+	return err
+}
+
+// Addr returns address listened on server, host is the requested one which may be a name.
+func (l *remoteListener) Addr() net.Addr {
+	return forwardAddr{l.msg.Addr, l.msg.Port}
+}
+
+// forwardAddr is address of a remote forward, host could be a name.
+type forwardAddr struct {
+	host string
+	port uint32
+}
+
+func (a forwardAddr) Network() string { return "tcp" }
+func (a forwardAddr) String() string {
+	return net.JoinHostPort(a.host, strconv.FormatUint(uint64(a.port), 10))
+}
+
+// channelConn is a forwarded connection over ssh channel, deadlines are not supported.
+type channelConn struct {
+	ssh.Channel
+	local, remote net.Addr
+}
+
+func (c *channelConn) LocalAddr() net.Addr  { return c.local }
+func (c *channelConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *channelConn) SetDeadline(t time.Time) error {
+	return errors.New("deadline is not supported")
+}
+
+func (c *channelConn) SetReadDeadline(t time.Time) error {
+	return errors.New("deadline is not supported")
+}
+
+func (c *channelConn) SetWriteDeadline(t time.Time) error {
+	return errors.New("deadline is not supported")
+}
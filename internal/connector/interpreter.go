@@ -0,0 +1,50 @@
+package connector
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// interpreter describes how a program runs commands of task.
+type interpreter struct {
+	flag string // flag to pass command as argument
+	ext  string // extension of script file required by program
+	file string // flag to run script file, if any
+}
+
+// interpreters are known programs to run commands with, others require shell flag or shell stdin.
+var interpreters = map[string]interpreter{
+	"sh":      {flag: "-c"},
+	"bash":    {flag: "-c"},
+	"dash":    {flag: "-c"},
+	"ksh":     {flag: "-c"},
+	"zsh":     {flag: "-c"},
+	"fish":    {flag: "-c"},
+	"python":  {flag: "-c"},
+	"python3": {flag: "-c"},
+	"perl":    {flag: "-e"},
+	"ruby":    {flag: "-e"},
+	"node":    {flag: "-e"},
+	"php":     {flag: "-r"},
+	"pwsh":    {flag: "-Command", ext: ".ps1", file: "-File"},
+}
+
+// interpreterOf resolves interpreter of task, flag of task takes precedence over known one.
+func interpreterOf(tr Task) (interpreter, error) {
+	known, ok := interpreters[filepath.Base(tr.Shell())]
+	if tr.ShellFlag() != "" {
+		known.flag = tr.ShellFlag()
+	} else if !ok && !tr.ShellStdin() {
+		return known, fmt.Errorf("shell: [%s] is unknown, please set shell-flag or shell-stdin for it", tr.Shell())
+	}
+	return known, nil
+}
+
+// interpreterArgs returns program and flag of task's interpreter followed by command,
+// command is left out when it's fed through stdin.
+func interpreterArgs(tr Task, ip interpreter, cmd string) []string {
+	if tr.ShellStdin() {
+		return []string{tr.Shell()}
+	}
+	return []string{tr.Shell(), ip.flag, cmd}
+}
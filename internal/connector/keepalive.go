@@ -0,0 +1,54 @@
+package connector
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Keepalive sends keepalive requests to server through client every interval until client
+// is closed, client is closed if no reply is received for countMax intervals, eg: NAT
+// dropped the idle connection. Keepalive is disabled if interval is zero.
+func Keepalive(client *ssh.Client, interval time.Duration, countMax int) {
+	if interval <= 0 {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(done)
+	}()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var replied chan struct{} // closed when last request is replied
+		missed := 0
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if replied != nil {
+				select {
+				case <-replied:
+					missed = 0
+				default:
+					// last request is still waiting for reply
+					if missed++; missed >= countMax {
+						client.Close()
+						return
+					}
+					continue
+				}
+			}
+			replied = make(chan struct{})
+			go func(replied chan struct{}) {
+				// any reply, even a failure, means server is alive
+				if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+					close(replied)
+				}
+			}(replied)
+		}
+	}()
+}
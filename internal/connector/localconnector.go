@@ -7,7 +7,10 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
+	"path/filepath"
+	"strings"
 
+	"github.com/jevi061/ops/internal/shellquote"
 	"github.com/rs/xid"
 )
 
@@ -24,11 +27,6 @@ type LocalConnector struct {
 	promet  string
 }
 
-var shellCommandArgs = map[string]string{
-	"sh":   "-c",
-	"bash": "-c",
-}
-
 func NewLocalConnector() *LocalConnector {
 	return &LocalConnector{id: xid.New().String(), local: true, host: "localhost"}
 }
@@ -61,41 +59,61 @@ func (r *LocalConnector) Run(tr Task, options *RunOptions) error {
 	if r.running {
 		return errors.New("connector is already running")
 	}
+	if tr.Action() != nil {
+		return fmt.Errorf("task: %s is not allowed to run on local", tr.Name())
+	}
+	ip, err := interpreterOf(tr)
+	if err != nil {
+		return err
+	}
+	if _, err := exec.LookPath(tr.Shell()); err != nil && !options.DryRun {
+		return fmt.Errorf("interpreter: [%s] is not found on %s", tr.Shell(), r.host)
+	}
+	dir, err := localDir(tr.Dir(), tr.Environments())
+	if err != nil {
+		return err
+	}
+	if dir != "" && !options.DryRun {
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			return fmt.Errorf("directory: [%s] does not exist on %s", dir, r.host)
+		}
+	}
+	for k := range tr.Environments() {
+		if tr.Become() && !shellquote.IsName(k) {
+			return fmt.Errorf("invalid environment variable name: %q", k)
+		}
+	}
+	// running is set after checks, so failed checks do not block following tasks
 	if !options.DryRun {
 		r.running = true
 	}
-	flag, ok := shellCommandArgs[tr.Shell()]
-	if !ok {
-		return fmt.Errorf("shell: [%s] is not supported, please use sh、bash instead", tr.Shell())
-	}
 	for _, trCmd := range tr.Commands() {
-		cmd := exec.Command(tr.Shell(), flag, trCmd)
+		args := interpreterArgs(tr, ip, trCmd)
+		if tr.Become() {
+			// sudo resets environments, pass them through env
+			args = append(append(becomeArgs(becomePrompt(r.ID()), tr.BecomeUser()), envArgs(tr.Environments())...), args...)
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Dir = dir
 		jenvs := make([]string, 0)
 		for k, v := range tr.Environments() {
 			jenvs = append(jenvs, fmt.Sprintf("%s=%s", k, v))
 		}
 		cmd.Env = append(os.Environ(), jenvs...)
 		r.exec = cmd
-		var err error
-		r.stdout, err = cmd.StdoutPipe()
-		if err != nil {
-			return err
-		}
-
-		r.stderr, err = cmd.StderrPipe()
-		if err != nil {
-			return err
-		}
-
-		r.stdin, err = cmd.StdinPipe()
-		if err != nil {
+		if err := r.pipe(cmd); err != nil {
+			r.running = false
 			return err
 		}
 		if options.Debug || options.DryRun {
-			fmt.Printf("%s%s %s %s\n", r.Promet(), tr.Shell(), flag, trCmd)
+			if dir != "" {
+				fmt.Printf("%scd -- %s\n", r.Promet(), shellquote.Quote(dir))
+			}
+			fmt.Printf("%s%s\n", r.Promet(), shellquote.Join(args...))
 		}
 		if !options.DryRun {
 			if err := r.exec.Start(); err != nil {
+				r.running = false
 				return err
 			}
 		}
@@ -103,8 +121,56 @@ func (r *LocalConnector) Run(tr Task, options *RunOptions) error {
 	return nil
 }
 
-func (r *LocalConnector) Upload(src, dest string) error {
-	return errors.New("upload task is not allowed to run on local")
+// localDir expands variables of envs or local environments and leading ~ in dir, like a
+// remote shell does for remote tasks.
+func localDir(dir string, envs map[string]string) (string, error) {
+	dir = os.Expand(dir, func(name string) string {
+		if v, ok := envs[name]; ok {
+			return v
+		}
+		return os.Getenv(name)
+	})
+	if !strings.HasPrefix(dir, "~") {
+		return dir, nil
+	}
+	name, rest, _ := strings.Cut(dir[1:], "/")
+	var home string
+	if name == "" {
+		h, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("expand directory: [%s] failed: %w", dir, err)
+		}
+		home = h
+	} else {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", fmt.Errorf("expand directory: [%s] failed: %w", dir, err)
+		}
+		home = u.HomeDir
+	}
+	return filepath.Join(home, rest), nil
+}
+
+// pipe connects stdin, stdout and stderr of connector to cmd.
+func (r *LocalConnector) pipe(cmd *exec.Cmd) error {
+	var err error
+	if r.stdout, err = cmd.StdoutPipe(); err != nil {
+		return err
+	}
+	if r.stderr, err = cmd.StderrPipe(); err != nil {
+		return err
+	}
+	r.stdin, err = cmd.StdinPipe()
+	return err
+}
+
+func (r *LocalConnector) Output(cmd string) ([]byte, error) {
+	out, err := exec.Command("sh", "-c", cmd).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return out, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return out, err
 }
 
 func (r *LocalConnector) Wait() error {
@@ -130,6 +196,10 @@ func (r *LocalConnector) Host() string {
 	return r.host
 }
 
+func (r *LocalConnector) Name() string {
+	return r.host
+}
+
 func (r *LocalConnector) ID() string {
 	return r.id
 }
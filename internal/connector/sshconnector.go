@@ -6,31 +6,65 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/jevi061/ops/internal/shellquote"
 	"github.com/jevi061/ops/internal/termsize"
+	"github.com/pkg/sftp"
 	"github.com/rs/xid"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/term"
 )
 
+// promptLock serializes password prompts of connectors connecting or running concurrently.
+var promptLock sync.Mutex
+
+// readPassword asks user for password of user@host in terminal.
+func readPassword(user, host string) (string, error) {
+	promptLock.Lock()
+	defer promptLock.Unlock()
+	fmt.Printf("%s@%s's password: ", user, host)
+	pass, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println("")
+	if err != nil {
+		return "", fmt.Errorf("read password failed: %w", err)
+	}
+	return string(pass), nil
+}
+
 type SSHConnector struct {
 	id            string
 	local         bool
 	host          string
+	name          string
 	port          uint
 	user          string
 	password      string
+	sudoPassword  string
+	dir           string // default working directory of tasks
+	forwardAgent  bool
+	timeout       time.Duration // timeout of connecting
+	keepalive     time.Duration // interval of keepalive requests
+	keepaliveMax  int           // unanswered keepalive requests to drop connection
+	dropped       chan struct{} // closed when connection is closed
+	sendEnv       []string      // patterns of local env vars to send
 	conn          *ssh.Client
+	sftp          *sftp.Client    // opened on demand
+	forwards      *remoteForwards // remote forwards of conn, set up on demand
 	session       *ssh.Session
 	stdin         io.WriteCloser
 	stdout        io.Reader
 	stderr        io.Reader
 	sessionOpened bool
-	promet        string // output prefix
+	action        chan error       // result of running action of task
+	programs      map[string]error // results of looking up programs
+	promet        string           // output prefix
 }
 type SSHTaskRunnerOption func(*SSHConnector)
 
@@ -53,8 +87,44 @@ func WithPassword(password string) SSHTaskRunnerOption {
 		s.password = password
 	}
 }
+func WithSudoPassword(password string) SSHTaskRunnerOption {
+	return func(s *SSHConnector) {
+		s.sudoPassword = password
+	}
+}
+func WithDefaultDir(dir string) SSHTaskRunnerOption {
+	return func(s *SSHConnector) {
+		s.dir = dir
+	}
+}
+func WithForwardAgent(forward bool) SSHTaskRunnerOption {
+	return func(s *SSHConnector) {
+		s.forwardAgent = forward
+	}
+}
+func WithSendEnv(patterns []string) SSHTaskRunnerOption {
+	return func(s *SSHConnector) {
+		s.sendEnv = patterns
+	}
+}
+func WithConnectTimeout(timeout time.Duration) SSHTaskRunnerOption {
+	return func(s *SSHConnector) {
+		s.timeout = timeout
+	}
+}
+func WithKeepalive(interval time.Duration, countMax int) SSHTaskRunnerOption {
+	return func(s *SSHConnector) {
+		s.keepalive = interval
+		s.keepaliveMax = countMax
+	}
+}
+func WithAlias(name string) SSHTaskRunnerOption {
+	return func(s *SSHConnector) {
+		s.name = name
+	}
+}
 func NewSSHConnector(host string, options ...SSHTaskRunnerOption) *SSHConnector {
-	r := &SSHConnector{id: xid.New().String(), local: false, host: host, port: 22}
+	r := &SSHConnector{id: xid.New().String(), local: false, host: host, port: 22, timeout: 5 * time.Second, keepaliveMax: 3}
 	for _, option := range options {
 		option(r)
 	}
@@ -84,12 +154,10 @@ func (r *SSHConnector) Connect() error {
 		authMethods = append(authMethods, ssh.Password(r.password))
 	}
 	if len(authMethods) <= 0 {
-		fmt.Printf("%s@%s's password: ", r.user, r.host)
-		if pass, err := term.ReadPassword(int(os.Stdin.Fd())); err != nil {
-			return fmt.Errorf("read password failed: %w", err)
+		if pass, err := readPassword(r.user, r.host); err != nil {
+			return err
 		} else {
-			fmt.Println("")
-			r.password = string(pass)
+			r.password = pass
 			authMethods = append(authMethods, ssh.Password(r.password))
 		}
 	}
@@ -97,19 +165,17 @@ func (r *SSHConnector) Connect() error {
 		User:            r.user,
 		Auth:            authMethods,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         5 * time.Second,
+		Timeout:         r.timeout,
 	}
-	conn, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", r.host, r.port), config)
+	conn, err := dial(fmt.Sprintf("%s:%d", r.host, r.port), config)
 	if err != nil {
 		if strings.Contains(err.Error(), "unable to authenticate") && !strings.Contains(err.Error(), "password") {
-			fmt.Printf("%s@%s's password: ", r.user, r.host)
-			if pass, err := term.ReadPassword(int(os.Stdin.Fd())); err != nil {
-				return fmt.Errorf("read password failed: %w", err)
+			if pass, err := readPassword(r.user, r.host); err != nil {
+				return err
 			} else {
-				fmt.Println("")
-				r.password = string(pass)
+				r.password = pass
 				config.Auth = append(authMethods, ssh.Password(r.password))
-				conn, err = ssh.Dial("tcp", fmt.Sprintf("%s:%d", r.host, r.port), config)
+				conn, err = dial(fmt.Sprintf("%s:%d", r.host, r.port), config)
 				if err != nil {
 					return err
 				}
@@ -118,41 +184,193 @@ func (r *SSHConnector) Connect() error {
 			return err
 		}
 	}
+	if r.forwardAgent {
+		if err := ForwardAgent(conn); err != nil {
+			conn.Close()
+			return err
+		}
+	}
 	r.conn = conn
+	dropped := make(chan struct{})
+	r.dropped = dropped
+	go func() {
+		conn.Wait()
+		close(dropped)
+	}()
+	Keepalive(conn, r.keepalive, r.keepaliveMax)
+	return nil
+}
+
+// dial connects to ssh server at addr, timeout of config applies to handshake too, so
+// servers accepting connections but not responding do not block.
+func dial(addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	nc, err := net.DialTimeout("tcp", addr, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if config.Timeout > 0 {
+		nc.SetDeadline(time.Now().Add(config.Timeout))
+	}
+	c, chans, reqs, err := ssh.NewClientConn(nc, addr, config)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	nc.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// reconnect connects to server again if connection was dropped, eg: by keepalive or idle
+// timeout of firewalls during long local tasks.
+func (r *SSHConnector) reconnect() error {
+	select {
+	case <-r.dropped:
+	default:
+		return nil
+	}
+	return r.redial()
+}
+
+// redial closes connection and connects to server again.
+func (r *SSHConnector) redial() error {
+	if r.sftp != nil {
+		r.sftp.Close()
+		r.sftp = nil
+	}
+	r.conn.Close()
+	if err := r.Connect(); err != nil {
+		return fmt.Errorf("reconnect to %s failed: %w", r.Name(), err)
+	}
 	return nil
 }
+
+// newSession opens a session, the connection is reconnected first if it was dropped. Without
+// keepalive a dead connection is only noticed by failing to open session, which is retried
+// once after reconnecting.
+func (r *SSHConnector) newSession() (*ssh.Session, error) {
+	if err := r.reconnect(); err != nil {
+		return nil, err
+	}
+	session, err := r.conn.NewSession()
+	var rejected *ssh.OpenChannelError
+	if err == nil || errors.As(err, &rejected) {
+		return session, err
+	}
+	if err := r.redial(); err != nil {
+		return nil, err
+	}
+	return r.conn.NewSession()
+}
+
+// Client returns ssh client of connector, it's nil before connected.
+func (r *SSHConnector) Client() *ssh.Client {
+	return r.conn
+}
+
 func (r *SSHConnector) Run(tr Task, options *RunOptions) error {
 	if r.sessionOpened {
 		return errors.New("another seesion is using")
 	}
-	// prepare cmd
-	envs := make([]string, 0)
+	if tr.Action() != nil {
+		return r.runAction(tr, options)
+	}
+	// prepare cmd, sent vars are assigned in command as sshd accepts few of them by default
+	envs := MatchEnvs(r.sendEnv)
 	for k, v := range tr.Environments() {
-		envs = append(envs, fmt.Sprintf("%s=%s", k, v))
+		envs[k] = v
 	}
-	envStr := strings.Join(envs, " ")
-	flag, ok := shellCommandArgs[tr.Shell()]
-	if !ok {
-		return fmt.Errorf("shell: [%s] is not supported,please use sh and bash instead", tr.Shell())
+	envStr, err := shellquote.Environ(envs)
+	if err != nil {
+		return err
+	}
+	ip, err := interpreterOf(tr)
+	if err != nil {
+		return err
 	}
+	dir := tr.Dir()
+	if dir == "" {
+		dir = r.dir
+	}
+	if !options.DryRun {
+		if err := r.lookPath(tr.Shell()); err != nil {
+			return err
+		}
+		if dir != "" {
+			if err := r.checkDir(dir); err != nil {
+				return err
+			}
+		}
+	}
+	sudoPrompt := becomePrompt(r.ID())
+	var sudo, env []string
+	var sudoPass *string // password fed to sudo ahead of input of task
+	if tr.Become() {
+		sudo = becomeArgs(sudoPrompt, tr.BecomeUser())
+		if tr.Stdin() != nil && !options.DryRun {
+			if sudo, sudoPass, err = r.becomeStdin(tr.BecomeUser()); err != nil {
+				return err
+			}
+		}
+		env = envArgs(envs)
+	}
+	// scripts written but not run yet are removed if task fails to start
+	var scriptDir string
+	defer func() {
+		if scriptDir != "" {
+			r.Output("rm -rf -- " + shellquote.Quote(scriptDir))
+		}
+	}()
 	for _, trCmd := range tr.Commands() {
-		cmd := fmt.Sprintf("%s %s '%s'", tr.Shell(), flag, trCmd)
-		sudoPrompt := fmt.Sprintf(`[sudo via ops, id=%s] password:`, r.ID())
-		if strings.Contains(cmd, "sudo") {
-			cmd = strings.ReplaceAll(cmd, "sudo", fmt.Sprintf(`sudo -E -p "%s"`, sudoPrompt))
+		// multi-line commands and scripts of known interpreters are written to a remote temp file
+		// and run from there, instead of being quoted as an argument of shell
+		var cmd, script string
+		if !tr.ShellStdin() && tr.ShellFlag() == "" && strings.Contains(trCmd, "\n") {
+			script = trCmd
+			at := `"${TMPDIR:-/tmp}/ops-XXXXXX"`
+			if !options.DryRun {
+				// scripts run by other users than root need to be readable by them
+				readable := tr.Become() && tr.BecomeUser() != "" && tr.BecomeUser() != "root"
+				if scriptDir, err = r.writeScript(script, "script"+ip.ext, readable); err != nil {
+					return fmt.Errorf("write script to %s failed: %w", r.host, err)
+				}
+				at = shellquote.Quote(scriptDir)
+			}
+			args := []string{tr.Shell()}
+			if ip.file != "" {
+				args = append(args, ip.file)
+			}
+			run := joinWords(envStr, shellquote.Join(args...), `"$ops_dir/script`+ip.ext+`"`)
+			if tr.Become() {
+				run = joinWords(shellquote.Join(append(append(sudo, env...), args...)...), `"$ops_dir/script`+ip.ext+`"`)
+			}
+			cmd = fmt.Sprintf(`ops_dir=%s; trap 'rm -rf -- "$ops_dir"' EXIT; %s`, at, run)
+		} else if tr.Become() {
+			cmd = shellquote.Join(append(append(sudo, env...), interpreterArgs(tr, ip, trCmd)...)...)
+		} else {
+			cmd = joinWords(envStr, shellquote.Join(interpreterArgs(tr, ip, trCmd)...))
+		}
+		if dir != "" {
+			cmd = fmt.Sprintf("cd -- %s || exit; %s", shellquote.Path(dir), cmd)
 		}
-		cmd = envStr + " " + cmd
 		if options.Debug || options.DryRun {
 			fmt.Printf("%s%s\n", r.Promet(), cmd)
+			if script != "" {
+				fmt.Print(prefixLines(script, r.Promet()+"  "))
+			}
 		}
 		if !options.DryRun {
 			// prepare session
-			session, err := r.conn.NewSession()
+			session, err := r.newSession()
 			if err != nil {
 				return err
 			}
 			r.session = session
 			r.sessionOpened = true
+			if r.forwardAgent {
+				if err := agent.RequestAgentForwarding(session); err != nil {
+					return fmt.Errorf("request agent forwarding failed: %w", err)
+				}
+			}
 			r.stdin, err = r.session.StdinPipe()
 			if err != nil {
 				return err
@@ -165,15 +383,18 @@ func (r *SSHConnector) Run(tr Task, options *RunOptions) error {
 			if err != nil {
 				return err
 			}
-			// setup envs
-			for k, v := range tr.Environments() {
-				r.session.Setenv(k, v)
+			// answer sudo prompt in terminal with password when become
+			if tr.Become() && tr.Stdin() == nil {
+				password := r.sudoPassword
+				if password == "" {
+					password = r.password
+				}
+				r.stdout = &passReader{host: r.host, user: r.user, password: password, expect: sudoPrompt, reader: bufio.NewReader(stdout), stdin: r.stdin}
+			} else {
+				r.stdout = stdout
 			}
-			// setup sshpass
-			r.stdout = &passReader{host: r.host, user: r.user, password: r.password, expect: sudoPrompt, reader: bufio.NewReader(stdout), stdin: r.stdin}
 
 			if tr.Stdin() == nil {
-				fmt.Println("stdin is not nil,request pty")
 				// request pty
 				// Set up terminal modes
 				modes := ssh.TerminalModes{
@@ -193,25 +414,211 @@ func (r *SSHConnector) Run(tr Task, options *RunOptions) error {
 			if err := r.session.Start(cmd); err != nil {
 				return err
 			}
+			// the script is removed by trap of cmd from now on
+			scriptDir = ""
+			if sudoPass != nil {
+				if _, err := io.WriteString(r.stdin, *sudoPass+"\n"); err != nil {
+					return fmt.Errorf("send sudo password to %s failed: %w", r.host, err)
+				}
+			}
+		}
+
+	}
+	return nil
+
+}
+
+// becomeStdin returns sudo arguments for task with input, which runs without terminal for
+// sudo to prompt in. If password is required, it's returned to be fed through stdin.
+func (r *SSHConnector) becomeStdin(user string) ([]string, *string, error) {
+	if _, err := r.output(shellquote.Join(append(becomeStdinArgs(user, false), "true")...), nil); err == nil {
+		return becomeStdinArgs(user, false), nil, nil
+	}
+	password := r.sudoPassword
+	if password == "" {
+		password = r.password
+	}
+	if password == "" {
+		pass, err := readPassword(r.user, r.host)
+		if err != nil {
+			return nil, nil, err
+		}
+		r.sudoPassword = pass
+		password = pass
+	}
+	// password is checked in a session of its own, as sudo reading a wrong one from stdin of
+	// task would consume input of task
+	if _, err := r.output(shellquote.Join(append(becomeStdinArgs(user, true), "true")...), strings.NewReader(password+"\n")); err != nil {
+		return nil, nil, fmt.Errorf("become on %s failed, sudo password may be incorrect: %w", r.host, err)
+	}
+	return becomeStdinArgs(user, true), &password, nil
+}
+
+// runAction runs action of task in process, its output is read through stdout of connector.
+func (r *SSHConnector) runAction(tr Task, options *RunOptions) error {
+	if options.DryRun {
+		return nil
+	}
+	var stdin *io.PipeReader
+	var stdout, stderr *io.PipeWriter
+	stdin, r.stdin = io.Pipe()
+	r.stdout, stdout = io.Pipe()
+	r.stderr, stderr = io.Pipe()
+	r.action = make(chan error, 1)
+	r.sessionOpened = true
+	go func() {
+		err := tr.Action()(r, stdin, stdout)
+		// input not consumed by action is discarded
+		stdin.CloseWithError(errors.New("action of task finished"))
+		stdout.Close()
+		stderr.Close()
+		r.action <- err
+	}()
+	return nil
+}
+
+// Dial connects to addr from remote server, it's used by tunnels.
+func (r *SSHConnector) Dial(network, addr string) (net.Conn, error) {
+	return r.conn.Dial(network, addr)
+}
+
+// Listen listens on addr of remote server, it's used by tunnels. Host of addr is resolved
+// by server, eg: localhost is loopback of server.
+func (r *SSHConnector) Listen(network, addr string) (net.Listener, error) {
+	if network != "tcp" {
+		return nil, fmt.Errorf("unsupported network: %s", network)
+	}
+	if r.forwards == nil || r.forwards.client != r.conn {
+		forwards, err := newRemoteForwards(r.conn)
+		if err != nil {
+			return nil, err
+		}
+		r.forwards = forwards
+	}
+	return r.forwards.listen(addr)
+}
+
+// SFTP returns SFTP client over connection of connector, which is opened on first call.
+func (r *SSHConnector) SFTP() (*sftp.Client, error) {
+	if r.sftp != nil {
+		return r.sftp, nil
+	}
+	if err := r.reconnect(); err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(r.conn)
+	if err != nil {
+		return nil, fmt.Errorf("open sftp session to %s failed: %w", r.Name(), err)
+	}
+	r.sftp = client
+	return client, nil
+}
+
+// lookPath ensures program is available on remote, results are cached per connector.
+func (r *SSHConnector) lookPath(program string) error {
+	if r.programs == nil {
+		r.programs = make(map[string]error)
+	}
+	if err, ok := r.programs[program]; ok {
+		return err
+	}
+	session, err := r.newSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	if err := session.Run("command -v " + shellquote.Quote(program) + " >/dev/null 2>&1"); err != nil {
+		var exitErr *ssh.ExitError
+		if !errors.As(err, &exitErr) {
+			return err
+		}
+		r.programs[program] = fmt.Errorf("interpreter: [%s] is not found on %s", program, r.Name())
+	} else {
+		r.programs[program] = nil
+	}
+	return r.programs[program]
+}
+
+func (r *SSHConnector) Output(cmd string) ([]byte, error) {
+	if r.dir != "" {
+		cmd = fmt.Sprintf("cd -- %s || exit; %s", shellquote.Path(r.dir), cmd)
+	}
+	return r.output(cmd, nil)
+}
+
+// output runs cmd with stdin in a session of its own and returns its stdout, stderr is
+// returned within error if it fails.
+func (r *SSHConnector) output(cmd string, stdin io.Reader) ([]byte, error) {
+	session, err := r.newSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	var stderr bytes.Buffer
+	session.Stdin = stdin
+	session.Stderr = &stderr
+	out, err := session.Output(cmd)
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return out, fmt.Errorf("%w: %s", err, msg)
 		}
+	}
+	return out, err
+}
 
+// checkDir ensures directory exists on remote.
+func (r *SSHConnector) checkDir(dir string) error {
+	session, err := r.newSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	if err := session.Run("test -d " + shellquote.Path(dir)); err != nil {
+		var exitErr *ssh.ExitError
+		if !errors.As(err, &exitErr) {
+			return err
+		}
+		return fmt.Errorf("directory: [%s] does not exist on %s", dir, r.Name())
 	}
 	return nil
+}
 
+// writeScript writes content to a file named name in a new remote temp directory, and returns
+// path of the directory. The directory is only accessible by login user, unless readable is
+// true, then the file could be read by others knowing the random name of directory.
+func (r *SSHConnector) writeScript(content, name string, readable bool) (string, error) {
+	file := shellquote.Quote(name)
+	cmd := fmt.Sprintf(`umask 077 && dir=$(mktemp -d "${TMPDIR:-/tmp}/ops-XXXXXX") && cat > "$dir/"%s`, file)
+	if readable {
+		cmd += fmt.Sprintf(` && chmod 711 "$dir" && chmod 644 "$dir/"%s`, file)
+	}
+	out, err := r.output(cmd+` && printf %s "$dir"`, strings.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
 }
 
 func (r *SSHConnector) Wait() error {
 	if !r.sessionOpened {
 		return errors.New("wait on closed ssh session is not allowed")
 	}
+	if r.action != nil {
+		err := <-r.action
+		r.action = nil
+		r.sessionOpened = false
+		return err
+	}
 	err := r.session.Wait()
 	r.session.Close()
 	r.sessionOpened = false
 	return err
 }
 func (r *SSHConnector) Close() error {
-
-	if r.sessionOpened {
+	if r.sftp != nil {
+		r.sftp.Close()
+	}
+	if r.sessionOpened && r.action == nil {
 		if err := r.session.Close(); err != nil {
 			return err
 		}
@@ -242,6 +649,13 @@ func (r *SSHConnector) Host() string {
 	return r.host
 }
 
+func (r *SSHConnector) Name() string {
+	if r.name != "" {
+		return r.name
+	}
+	return r.host
+}
+
 func (r *SSHConnector) ID() string {
 	return r.id
 }
@@ -252,6 +666,15 @@ func (r *SSHConnector) Signal(sig os.Signal) error {
 	if !r.sessionOpened {
 		return fmt.Errorf("session is not open")
 	}
+	if r.action != nil {
+		// actions through SFTP are interrupted by closing it
+		if r.sftp != nil {
+			client := r.sftp
+			r.sftp = nil
+			return client.Close()
+		}
+		return nil
+	}
 
 	switch sig {
 	case os.Interrupt:
@@ -285,12 +708,10 @@ func (pr *passReader) Read(data []byte) (int, error) {
 	if strings.Contains(string(pr.content), pr.expect) {
 		pr.content = nil
 		if pr.password == "" {
-			fmt.Printf("%s@%s's password: ", pr.user, pr.host)
-			if pass, err := term.ReadPassword(int(os.Stdin.Fd())); err != nil {
-				return 0, fmt.Errorf("read password failed: %w", err)
+			if pass, err := readPassword(pr.user, pr.host); err != nil {
+				return 0, err
 			} else {
-				fmt.Println("")
-				pr.password = string(pass)
+				pr.password = pass
 			}
 		}
 		if _, err := io.Copy(pr.stdin, bytes.NewBuffer([]byte(pr.password+"\n"))); err != nil {
@@ -299,3 +720,20 @@ func (pr *passReader) Read(data []byte) (int, error) {
 	}
 	return copy(data, []byte{b}), nil
 }
+
+// joinWords joins non empty words of command line with space.
+func joinWords(words ...string) string {
+	nonEmpty := make([]string, 0, len(words))
+	for _, w := range words {
+		if w != "" {
+			nonEmpty = append(nonEmpty, w)
+		}
+	}
+	return strings.Join(nonEmpty, " ")
+}
+
+// prefixLines prefixes each line of s, and ensures a trailing newline.
+func prefixLines(s, prefix string) string {
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	return prefix + strings.Join(lines, "\n"+prefix) + "\n"
+}
@@ -2,34 +2,78 @@ package connector
 
 import (
 	"io"
+
+	"github.com/jevi061/ops/internal/tunnel"
 )
 
 // Task represents executable/runnable task through connector
 type Task interface {
-	// Shell defines environment for command to run. Currently, only sh and bash are supported.
+	// Shell defines interpreter for command to run, eg: bash, python3.
 	Shell() string
+	// ShellFlag is flag of shell to pass command as argument, defaults to flag of known shells.
+	ShellFlag() string
+	// ShellStdin reports whether command is fed to shell through stdin.
+	ShellStdin() bool
 	// Shell command or scripts of task
 	Commands() []string
 	Environments() map[string]string
 	Stdin() func() (io.Reader, error)
-	Sudo() bool
+	// Become reports whether the task runs as another user through sudo
+	Become() bool
+	// BecomeUser is the user to become, empty means root
+	BecomeUser() string
 	Local() bool
 	Name() string
 	Desc() string
 	Prompt() string
+	// On returns server names, groups or tags the task is bound to, empty means all servers
+	On() []string
+	// Dir is working directory of task, defaults to connector's
+	Dir() string
+	// RunOnce reports whether the task should run on the first matched server only
+	RunOnce() bool
+	// Parallel reports whether the task runs on matched servers concurrently
+	Parallel() bool
+	// Bind specializes task for connector before running, eg: sync transfers compare
+	// files of connector with local ones
+	Bind(Connector, *RunOptions) (Task, error)
+	// Action runs task in process through connector instead of running commands, it reads input
+	// of task from stdin and writes output to stdout, eg: uploads through SFTP
+	Action() func(c Connector, stdin io.Reader, stdout io.Writer) error
+	// Verify checks results of task on connector after it succeeds, eg: checksums of uploaded files
+	Verify(Connector) error
+	// Tunnels are open through servers while task runs, eg: forwards to databases behind servers
+	Tunnels() []Tunnel
+}
+
+// Tunnel is a tunnel of spec through server.
+type Tunnel struct {
+	Server string
+	Spec   tunnel.Spec
 }
 
 // CommonTask is minimum unit of task with target runners for ops to run
 type CommonTask struct {
-	shell    string
-	commands []string
-	envs     map[string]string
-	stdin    func() (io.Reader, error) // input generator
-	sudo     bool
-	local    bool
-	name     string
-	desc     string
-	prompt   string // task prompt
+	shell      string
+	shellFlag  string
+	shellStdin bool
+	commands   []string
+	envs       map[string]string
+	stdin      func() (io.Reader, error) // input generator
+	become     bool
+	becomeUser string
+	local      bool
+	name       string
+	desc       string
+	prompt     string // task prompt
+	on         []string
+	runOnce    bool
+	parallel   bool
+	dir        string
+	binder     func(Connector, *RunOptions) (Task, error)
+	verifier   func(Connector) error
+	action     func(Connector, io.Reader, io.Writer) error
+	tunnels    []Tunnel
 }
 
 func NewCommonTask(options ...func(*CommonTask)) *CommonTask {
@@ -44,6 +88,16 @@ func WithShell(shell string) func(*CommonTask) {
 		ct.shell = shell
 	}
 }
+func WithShellFlag(flag string) func(*CommonTask) {
+	return func(ct *CommonTask) {
+		ct.shellFlag = flag
+	}
+}
+func WithShellStdin(stdin bool) func(*CommonTask) {
+	return func(ct *CommonTask) {
+		ct.shellStdin = stdin
+	}
+}
 func WithCommand(command ...string) func(*CommonTask) {
 	return func(ct *CommonTask) {
 		ct.commands = append(ct.commands, command...)
@@ -54,9 +108,10 @@ func WithEnvironments(envs map[string]string) func(*CommonTask) {
 		ct.envs = envs
 	}
 }
-func WithSudo(sudo bool) func(*CommonTask) {
+func WithBecome(become bool, user string) func(*CommonTask) {
 	return func(ct *CommonTask) {
-		ct.sudo = sudo
+		ct.become = become || user != ""
+		ct.becomeUser = user
 	}
 }
 func WithName(name string) func(*CommonTask) {
@@ -84,9 +139,55 @@ func WithStdin(stdin func() (io.Reader, error)) func(*CommonTask) {
 		ct.stdin = stdin
 	}
 }
+func WithOn(on ...string) func(*CommonTask) {
+	return func(ct *CommonTask) {
+		ct.on = append(ct.on, on...)
+	}
+}
+func WithDir(dir string) func(*CommonTask) {
+	return func(ct *CommonTask) {
+		ct.dir = dir
+	}
+}
+func WithBinder(binder func(Connector, *RunOptions) (Task, error)) func(*CommonTask) {
+	return func(ct *CommonTask) {
+		ct.binder = binder
+	}
+}
+func WithParallel(parallel bool) func(*CommonTask) {
+	return func(ct *CommonTask) {
+		ct.parallel = parallel
+	}
+}
+func WithAction(action func(Connector, io.Reader, io.Writer) error) func(*CommonTask) {
+	return func(ct *CommonTask) {
+		ct.action = action
+	}
+}
+func WithVerifier(verifier func(Connector) error) func(*CommonTask) {
+	return func(ct *CommonTask) {
+		ct.verifier = verifier
+	}
+}
+func WithTunnels(tunnels ...Tunnel) func(*CommonTask) {
+	return func(ct *CommonTask) {
+		ct.tunnels = append(ct.tunnels, tunnels...)
+	}
+}
+func WithRunOnce(runOnce bool) func(*CommonTask) {
+	return func(ct *CommonTask) {
+		ct.runOnce = runOnce
+	}
+}
 func (ct *CommonTask) Shell() string {
 	return ct.shell
 }
+func (ct *CommonTask) ShellFlag() string {
+	return ct.shellFlag
+}
+func (ct *CommonTask) ShellStdin() bool {
+	return ct.shellStdin
+}
 
 // Command return executable sh/bash commands
 func (ct *CommonTask) Commands() []string {
@@ -102,8 +203,11 @@ func (ct *CommonTask) Local() bool {
 	return ct.local
 }
 
-func (ct *CommonTask) Sudo() bool {
-	return ct.sudo
+func (ct *CommonTask) Become() bool {
+	return ct.become
+}
+func (ct *CommonTask) BecomeUser() string {
+	return ct.becomeUser
 }
 func (ct *CommonTask) Name() string {
 	return ct.name
@@ -114,3 +218,33 @@ func (ct *CommonTask) Desc() string {
 func (ct *CommonTask) Prompt() string {
 	return ct.prompt
 }
+func (ct *CommonTask) On() []string {
+	return ct.on
+}
+func (ct *CommonTask) RunOnce() bool {
+	return ct.runOnce
+}
+func (ct *CommonTask) Parallel() bool {
+	return ct.parallel
+}
+func (ct *CommonTask) Dir() string {
+	return ct.dir
+}
+func (ct *CommonTask) Bind(c Connector, options *RunOptions) (Task, error) {
+	if ct.binder == nil {
+		return ct, nil
+	}
+	return ct.binder(c, options)
+}
+func (ct *CommonTask) Action() func(Connector, io.Reader, io.Writer) error {
+	return ct.action
+}
+func (ct *CommonTask) Verify(c Connector) error {
+	if ct.verifier == nil {
+		return nil
+	}
+	return ct.verifier(c)
+}
+func (ct *CommonTask) Tunnels() []Tunnel {
+	return ct.tunnels
+}
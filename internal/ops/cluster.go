@@ -0,0 +1,219 @@
+package ops
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jevi061/ops/internal/connector"
+	"golang.org/x/term"
+)
+
+// cluster is an interactive shell running each line on enabled servers concurrently.
+type cluster struct {
+	conf       *Opsfile
+	exec       *cliExecutor
+	connectors []connector.Connector
+	disabled   map[string]bool // disabled servers by name
+}
+
+// Cluster opens an interactive shell to selected servers, each line runs on enabled servers
+// concurrently with output prefixed by servers, lines starting with : control the shell.
+func (ops *Ops) Cluster(sel *Selector) error {
+	cp := &connectorPreparer{}
+	prepared, err := cp.Prepare(ops.conf, sel)
+	if err != nil {
+		return err
+	}
+	remotes := make([]connector.Connector, 0, len(prepared))
+	for _, c := range prepared {
+		if !c.Local() {
+			remotes = append(remotes, c)
+		}
+	}
+	// unreachable servers abort the shell unless connect-policy skips them, like tasks
+	connectors := make([]connector.Connector, 0, len(remotes))
+	var failures []error
+	for i, err := range connect(remotes) {
+		c := remotes[i]
+		if err != nil {
+			failures = append(failures, &ConnectError{Host: c.Host(), Err: fmt.Errorf("connect to %s failed: %w", c.Name(), err)})
+			continue
+		}
+		defer c.Close()
+		connectors = append(connectors, c)
+	}
+	if len(failures) > 0 {
+		if ops.conf.ConnectPolicy != ConnectSkipUnreachable {
+			return errors.Join(failures...)
+		}
+		for _, err := range failures {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	if len(connectors) == 0 {
+		return errors.New("no server is connected")
+	}
+	exec := NewExecutor(ops.conf, ops.debug, false, true)
+	exec.stream = true
+	exec.AlignAndColorConnectorPromets(connectors)
+	cl := &cluster{conf: ops.conf, exec: exec, connectors: connectors, disabled: make(map[string]bool)}
+	return cl.loop()
+}
+
+// loop reads and runs lines until EOF or :quit, lines are edited with history in terminal.
+func (cl *cluster) loop() error {
+	fd := int(os.Stdin.Fd())
+	var readLine func() (string, error)
+	if term.IsTerminal(fd) {
+		t := term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, "")
+		readLine = func() (string, error) {
+			// terminal is raw only while reading, so output of commands is written as is
+			state, err := term.MakeRaw(fd)
+			if err != nil {
+				return "", err
+			}
+			defer term.Restore(fd, state)
+			if w, h, err := term.GetSize(fd); err == nil && w > 0 {
+				t.SetSize(w, h)
+			}
+			t.SetPrompt(cl.prompt())
+			return t.ReadLine()
+		}
+	} else {
+		scanner := bufio.NewScanner(os.Stdin)
+		readLine = func() (string, error) {
+			if scanner.Scan() {
+				return scanner.Text(), nil
+			}
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+	}
+	// interrupt commands instead of ops
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer func() {
+		signal.Stop(signals)
+		close(signals)
+	}()
+	go func() {
+		for sig := range signals {
+			for _, c := range cl.enabled() {
+				c.Signal(sig)
+			}
+		}
+	}()
+	fmt.Printf("Connected to %d servers, type :help for commands\n", len(cl.connectors))
+	for {
+		line, err := readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, ":"):
+			if cl.control(strings.Fields(line)) {
+				return nil
+			}
+		default:
+			cl.run(line)
+		}
+	}
+}
+
+// prompt shows number of enabled servers.
+func (cl *cluster) prompt() string {
+	return fmt.Sprintf("ops [%d/%d]> ", len(cl.enabled()), len(cl.connectors))
+}
+
+// enabled returns enabled connectors.
+func (cl *cluster) enabled() []connector.Connector {
+	enabled := make([]connector.Connector, 0, len(cl.connectors))
+	for _, c := range cl.connectors {
+		if !cl.disabled[c.Name()] {
+			enabled = append(enabled, c)
+		}
+	}
+	return enabled
+}
+
+// run runs line on enabled servers concurrently, only failures are reported as output
+// is already printed.
+func (cl *cluster) run(line string) {
+	targets := cl.enabled()
+	if len(targets) == 0 {
+		fmt.Println("No server is enabled, use :enable to enable servers")
+		return
+	}
+	t := connector.NewCommonTask(connector.WithName(line),
+		connector.WithShell(cl.conf.Shell),
+		connector.WithCommand(line),
+		connector.WithEnvironments(cl.conf.Environments.Envs),
+		connector.WithParallel(true))
+	opts := &connector.RunOptions{Debug: cl.exec.debug}
+	cl.exec.runParallel(t, targets, opts, nil, func(dura time.Duration, host string, err error, output string, summary string) {
+		if err != nil {
+			fmt.Printf("%s %s\n", red("Failure on "+host+":"), err)
+		}
+	})
+}
+
+// control runs command of shell, it reports whether to quit.
+func (cl *cluster) control(args []string) bool {
+	switch args[0] {
+	case ":quit", ":exit":
+		return true
+	case ":hosts":
+		for _, c := range cl.connectors {
+			state := green("enabled")
+			if cl.disabled[c.Name()] {
+				state = gray("disabled")
+			}
+			fmt.Printf("%s%s %s\n", c.Promet(), c.Name(), state)
+		}
+	case ":enable", ":disable":
+		patterns := args[1:]
+		if len(patterns) == 0 {
+			// all servers
+			patterns = []string{"*"}
+		}
+		matched := false
+		for _, c := range cl.connectors {
+			for _, p := range patterns {
+				if ok, _ := path.Match(p, c.Name()); ok {
+					cl.disabled[c.Name()] = args[0] == ":disable"
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			fmt.Printf("No server matches: %s\n", strings.Join(patterns, " "))
+		}
+	case ":help":
+		fmt.Print(`Lines run on enabled servers concurrently, use up and down keys for history.
+  :hosts                  list servers
+  :enable [SERVER...]     enable servers, names or globs, all servers by default
+  :disable [SERVER...]    disable servers, names or globs, all servers by default
+  :quit                   quit, or press Ctrl-D
+`)
+	default:
+		fmt.Printf("Unknown command: %s, type :help for commands\n", args[0])
+	}
+	return false
+}
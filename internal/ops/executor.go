@@ -3,6 +3,7 @@ package ops
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"hash/fnv"
 	"io"
@@ -10,6 +11,7 @@ import (
 	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/briandowns/spinner"
@@ -25,6 +27,10 @@ type cliExecutor struct {
 	debug         bool
 	dryRun        bool
 	alwaysConfirm bool
+	stream        bool // print output of tasks as it's produced, eg: ad-hoc commands
+	progress      *progress
+	failed        atomic.Bool                   // any task failed
+	unreachable   map[connector.Connector]error // skipped connectors by connect-policy
 }
 
 var (
@@ -33,27 +39,55 @@ var (
 )
 
 func NewExecutor(conf *Opsfile, debug bool, dryRun bool, alwaysConfirm bool) *cliExecutor {
-	return &cliExecutor{conf: conf, debug: debug, dryRun: dryRun, alwaysConfirm: alwaysConfirm}
+	return &cliExecutor{conf: conf, debug: debug, dryRun: dryRun, alwaysConfirm: alwaysConfirm, progress: &progress{},
+		unreachable: make(map[connector.Connector]error)}
+}
+
+// connect connects connectors concurrently, it returns errors of connecting in order of
+// connectors. Password prompts are serialized by connectors.
+func connect(connectors []connector.Connector) []error {
+	errs := make([]error, len(connectors))
+	var wg sync.WaitGroup
+	for i, c := range connectors {
+		wg.Add(1)
+		go func(i int, c connector.Connector) {
+			defer wg.Done()
+			errs[i] = c.Connect()
+		}(i, c)
+	}
+	wg.Wait()
+	return errs
 }
 func (e *cliExecutor) Execute(tasks []connector.Task, connectors []connector.Connector) error {
 	printer := newExecPrinter(tasks, connectors)
 	hasRemoteTask := e.hasRemoteTask(tasks)
 	// connect
+	needed := make([]connector.Connector, 0, len(connectors))
 	for _, c := range connectors {
-		remoteTaskWithoutConnectors := (!c.Local() && !hasRemoteTask)
-		if !remoteTaskWithoutConnectors {
-			if err := c.Connect(); err != nil {
-				return err
-			} else {
-				defer c.Close()
-			}
+		if c.Local() || hasRemoteTask {
+			needed = append(needed, c)
+		}
+	}
+	reachable := make([]connector.Connector, 0, len(needed))
+	var failures []error
+	for i, err := range connect(needed) {
+		c := needed[i]
+		if err != nil {
+			failures = append(failures, &ConnectError{Host: c.Host(), Err: fmt.Errorf("connect to %s failed: %w", c.Name(), err)})
+			e.unreachable[c] = err
+			continue
 		}
+		defer c.Close()
+		reachable = append(reachable, c)
+	}
+	if len(failures) > 0 && e.conf.ConnectPolicy != ConnectSkipUnreachable {
+		return errors.Join(failures...)
 	}
 	// relay signals to runners
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt)
 	go func() {
-		if err := e.RelaySignals(connectors, signals); err != nil {
+		if err := e.RelaySignals(reachable, signals); err != nil {
 			fmt.Fprintln(os.Stderr, "RUN ERROR:", err)
 			os.Exit(1)
 		}
@@ -63,50 +97,194 @@ func (e *cliExecutor) Execute(tasks []connector.Task, connectors []connector.Con
 		close(signals)
 	}()
 	// update prompets
-	if e.debug || e.dryRun {
+	if e.debug || e.dryRun || e.stream {
 		e.AlignAndColorConnectorPromets(connectors)
 	}
 	// execute tasks through connectors
 
 	sp := spinner.New(spinner.CharSets[11], 100*time.Millisecond, spinner.WithHiddenCursor(true), spinner.WithFinalMSG(""))
+	sp.PreUpdate = e.progress.update
+	opts := &connector.RunOptions{Debug: e.debug, DryRun: e.dryRun}
+	tunnels := newTunnels(e.conf)
+	defer tunnels.Close()
 	for _, t := range tasks {
-		for _, c := range connectors {
-			if t.Local() == c.Local() {
-				printer.PrintTaskHeader(t, '·')
-				//fmt.Printf("run task: [%s] on connector: [%s]\n", t.Name(), c.Host())
-				if !e.debug && !e.dryRun {
-					shouldConfirm := t.Prompt() != "" && !e.alwaysConfirm
-					if shouldConfirm {
-						if !askForConfirmation(t.Prompt()) {
-							return nil
-						}
-					}
-					sp.Start()
-				}
-				startAt := time.Now()
-				if err := c.Run(t, &connector.RunOptions{Debug: e.debug, DryRun: e.dryRun}); err != nil {
-					if e.conf.FailFast {
-						return err
-					} else {
-						fmt.Fprintln(os.Stderr, red(err.Error()))
-					}
-				}
-				if !e.dryRun {
-					output := e.HandleInputAndOutput(t, c)
-					sp.Stop()
-					err := c.Wait()
-					printer.PrintTaskStatus(startAt, c.Host(), t, err, output)
-					if err != nil && e.conf.FailFast {
-						return err
-					}
-				}
+		if err := e.runTask(t, connectors, opts, sp, printer, tunnels); err != nil {
+			if errors.Is(err, errCanceled) {
+				return nil
 			}
+			return err
 		}
 	}
 
 	return nil
 }
 
+// runTask runs task through its targets, tunnels of task are open while it runs. It returns
+// error only if the remaining tasks should not run.
+func (e *cliExecutor) runTask(t connector.Task, connectors []connector.Connector, opts *connector.RunOptions, sp *spinner.Spinner, printer *execPrinter, tunnels *tunnels) error {
+	targets, skipped := e.targets(t, connectors)
+	// unreachable servers are reported after task runs on the others
+	defer func() {
+		for i, c := range skipped {
+			if !t.Parallel() || (len(targets) == 0 && i == 0) {
+				printer.PrintTaskHeader(t, '·')
+			}
+			printer.printUnreachable(c.Host(), e.unreachable[c])
+			e.failed.Store(true)
+		}
+	}()
+	if len(targets) == 0 {
+		return nil
+	}
+	taskOpts := *opts
+	taskOpts.Targets = len(targets)
+	opts = &taskOpts
+	if err := tunnels.Open(t, opts); err != nil {
+		return err
+	}
+	defer tunnels.CloseTunnels()
+	if t.Parallel() && len(targets) > 1 {
+		printer.PrintTaskHeader(t, '·')
+		if err := e.runParallel(t, targets, opts, sp, printer.printStatus); err != nil && (errors.Is(err, errCanceled) || e.conf.FailFast) {
+			return err
+		}
+		return nil
+	}
+	for _, c := range targets {
+		printer.PrintTaskHeader(t, '·')
+		if !e.confirm(t) {
+			return errCanceled
+		}
+		if err := e.run(t, c, opts, sp, printer.PrintTaskStatus); err != nil && e.conf.FailFast {
+			return err
+		}
+	}
+	return nil
+}
+
+// errCanceled is returned when user declines to run a task.
+var errCanceled = errors.New("canceled")
+
+// targets returns connectors to run task through, and unreachable connectors skipped. Task
+// running once runs through the first reachable connector, unreachable ones are skipped only
+// if none is reachable.
+func (e *cliExecutor) targets(t connector.Task, connectors []connector.Connector) ([]connector.Connector, []connector.Connector) {
+	targets := make([]connector.Connector, 0)
+	skipped := make([]connector.Connector, 0)
+	for _, c := range connectors {
+		if !e.routes(t, c) {
+			continue
+		}
+		if _, ok := e.unreachable[c]; ok {
+			skipped = append(skipped, c)
+			continue
+		}
+		targets = append(targets, c)
+		if t.RunOnce() {
+			return targets, nil
+		}
+	}
+	return targets, skipped
+}
+
+// confirm asks user to confirm running task if it has a prompt.
+func (e *cliExecutor) confirm(t connector.Task) bool {
+	if e.debug || e.dryRun || e.alwaysConfirm || t.Prompt() == "" {
+		return true
+	}
+	return askForConfirmation(t.Prompt())
+}
+
+// reportFunc reports status of task finished in dura through a connector of host.
+type reportFunc func(dura time.Duration, host string, err error, output string, summary string)
+
+// statusFunc reports status of task run through a connector.
+type statusFunc func(startAt time.Time, host string, t connector.Task, err error, output string, summary string)
+
+// run runs task through connector and reports its status, the spinner is shown while running
+// if it's not nil, unless in debug or dry run mode.
+func (e *cliExecutor) run(t connector.Task, c connector.Connector, opts *connector.RunOptions, sp *spinner.Spinner, status statusFunc) (err error) {
+	defer func() {
+		if err != nil {
+			e.failed.Store(true)
+		}
+	}()
+	startAt := time.Now()
+	// specialize task for connector
+	bound, err := t.Bind(c, opts)
+	if err != nil {
+		status(startAt, c.Host(), t, err, "", "")
+		return err
+	}
+	spin := sp != nil && !e.debug && !e.dryRun && !e.stream
+	if spin {
+		sp.Start()
+	}
+	if err := c.Run(bound, opts); err != nil {
+		if spin {
+			sp.Stop()
+		}
+		status(startAt, c.Host(), bound, err, "", "")
+		return err
+	}
+	if e.dryRun {
+		return nil
+	}
+	output, summary, inputErr := e.HandleInputAndOutput(bound, c)
+	e.progress.reset(c)
+	err = c.Wait()
+	// failure of preparing input is the cause of remote failure if any, failure
+	// of sending input matters only if remote succeeds
+	var sendErr *sendError
+	if inputErr != nil && (err == nil || !errors.As(inputErr, &sendErr)) {
+		err = inputErr
+	}
+	if err == nil {
+		err = bound.Verify(c)
+	}
+	if spin {
+		sp.Stop()
+	}
+	status(startAt, c.Host(), bound, err, output, summary)
+	return err
+}
+
+// runParallel runs task through connectors concurrently, statuses are reported in order of
+// connectors after all of them finish. The spinner is shown while running if it's not nil.
+func (e *cliExecutor) runParallel(t connector.Task, targets []connector.Connector, opts *connector.RunOptions, sp *spinner.Spinner, report reportFunc) error {
+	if !e.confirm(t) {
+		return errCanceled
+	}
+	// spinner is shared by all connectors, so it's not started or stopped by each of them
+	spin := sp != nil && !e.debug && !e.dryRun && !e.stream
+	if spin {
+		sp.Start()
+	}
+	statuses := make([]func(), len(targets))
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, c := range targets {
+		wg.Add(1)
+		go func(i int, c connector.Connector) {
+			defer wg.Done()
+			errs[i] = e.run(t, c, opts, nil, func(startAt time.Time, host string, t connector.Task, err error, output, summary string) {
+				dura := time.Since(startAt)
+				statuses[i] = func() { report(dura, host, err, output, summary) }
+			})
+		}(i, c)
+	}
+	wg.Wait()
+	if spin {
+		sp.Stop()
+	}
+	for _, status := range statuses {
+		if status != nil {
+			status()
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // RelaySignals realy incoming signals to avaliable runners, it will block until signals chan closed
 func (e *cliExecutor) RelaySignals(runners []connector.Connector, signals chan os.Signal) error {
 	for {
@@ -123,13 +301,23 @@ func (e *cliExecutor) RelaySignals(runners []connector.Connector, signals chan o
 	}
 }
 
-func (e *cliExecutor) HandleInputAndOutput(task connector.Task, c connector.Connector) string {
+// summarizer is implemented by task inputs which could describe what they sent, eg: transfers.
+type summarizer interface {
+	Summary() string
+}
+
+// HandleInputAndOutput relays input and output of task through connector, it returns output
+// of task, summary of input if any, and error of generating or sending input.
+func (e *cliExecutor) HandleInputAndOutput(task connector.Task, c connector.Connector) (string, string, error) {
 	var wg sync.WaitGroup
 	var (
 		errOutput bytes.Buffer
 		outOutput bytes.Buffer
+		input     summarizer
+		inputErr  error
+		writeErr  error
 	)
-	if e.debug {
+	if e.debug || e.stream {
 		// copy remote computer's stdout to current
 		wg.Add(1)
 		go func(rn connector.Connector) {
@@ -168,19 +356,63 @@ func (e *cliExecutor) HandleInputAndOutput(task connector.Task, c connector.Conn
 	if task.Stdin() != nil {
 		stdin, err := task.Stdin()()
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			return outOutput.String() + errOutput.String()
+			// remote waits for input until stdin closed
+			inputErr = fmt.Errorf("prepare input of task: %s failed: %w", task.Name(), err)
+			c.Stdin().Close()
+		} else {
+			input, _ = stdin.(summarizer)
+			if p, ok := stdin.(progresser); ok && !e.debug {
+				e.progress.track(c, p)
+			}
+			wg.Add(1)
+			go func(rn connector.Connector) {
+				defer wg.Done()
+				defer rn.Stdin().Close()
+				src := &inputReader{reader: stdin}
+				if _, err := io.Copy(rn.Stdin(), src); src.err != nil {
+					inputErr = fmt.Errorf("prepare input of task: %s failed: %w", task.Name(), src.err)
+				} else if err != nil {
+					writeErr = fmt.Errorf("send input of task: %s failed: %w", task.Name(), err)
+				}
+			}(c)
 		}
-		wg.Add(1)
-		go func(rn connector.Connector) {
-			defer wg.Done()
-			defer rn.Stdin().Close()
-
-			io.Copy(rn.Stdin(), stdin)
-		}(c)
 	}
 	wg.Wait()
-	return outOutput.String() + errOutput.String()
+	summary := ""
+	if input != nil {
+		summary = input.Summary()
+	}
+	if inputErr == nil && writeErr != nil {
+		inputErr = &sendError{err: writeErr}
+	}
+	return outOutput.String() + errOutput.String(), summary, inputErr
+}
+
+// sendError is error of sending input to remote, which may exit without reading all input.
+type sendError struct {
+	err error
+}
+
+func (e *sendError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sendError) Unwrap() error {
+	return e.err
+}
+
+// inputReader keeps error of reading task input, to tell it from errors of sending input.
+type inputReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *inputReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
 }
 
 func (e *cliExecutor) AlignAndColorConnectorPromets(connectors []connector.Connector) {
@@ -211,6 +443,19 @@ func (e *cliExecutor) AlignAndColorConnectorPromets(connectors []connector.Conne
 
 }
 
+// routes reports whether task should run through connector, remote tasks bound to
+// servers by on are only routed to matched connectors.
+func (e *cliExecutor) routes(t connector.Task, c connector.Connector) bool {
+	if t.Local() != c.Local() {
+		return false
+	}
+	if c.Local() || len(t.On()) == 0 {
+		return true
+	}
+	s, ok := e.conf.Servers.Names[c.Name()]
+	return ok && e.conf.matchOn(s, t.On())
+}
+
 func (e *cliExecutor) hasRemoteTask(tasks []connector.Task) bool {
 	for _, t := range tasks {
 		if !t.Local() {
@@ -234,8 +479,11 @@ func (p *execPrinter) PrintTaskHeader(t connector.Task, divider byte) {
 	fmt.Printf("%s %s\n", title, suffix)
 }
 
-func (p *execPrinter) PrintTaskStatus(startAt time.Time, host string, t connector.Task, err error, output string) {
-	dura := time.Since(startAt)
+func (p *execPrinter) PrintTaskStatus(startAt time.Time, host string, t connector.Task, err error, output string, summary string) {
+	p.printStatus(time.Since(startAt), host, err, output, summary)
+}
+
+func (p *execPrinter) printStatus(dura time.Duration, host string, err error, output string, summary string) {
 	serverHost := host
 	w := runewidth.StringWidth(serverHost)
 	if w < p.maxConnHostLength {
@@ -245,8 +493,22 @@ func (p *execPrinter) PrintTaskStatus(startAt time.Time, host string, t connecto
 		fmt.Printf("Server: %s    Status: %s    Time: %s    Reason: %s\n", serverHost, red("Failure"), dura, red(err.Error()))
 		fmt.Println(red(output))
 	} else {
-		fmt.Printf("Server: %s    Status: %s    Time: %s\n", serverHost, green("Success"), dura)
+		if summary != "" {
+			fmt.Printf("Server: %s    Status: %s    Time: %s    Sent: %s\n", serverHost, green("Success"), dura, summary)
+		} else {
+			fmt.Printf("Server: %s    Status: %s    Time: %s\n", serverHost, green("Success"), dura)
+		}
+	}
+}
+
+// printUnreachable reports host is skipped as it's unreachable.
+func (p *execPrinter) printUnreachable(host string, err error) {
+	serverHost := host
+	w := runewidth.StringWidth(serverHost)
+	if w < p.maxConnHostLength {
+		serverHost = serverHost + strings.Repeat(" ", p.maxConnHostLength-w)
 	}
+	fmt.Printf("Server: %s    Status: %s    Reason: %s\n", serverHost, red("Unreachable"), red(err.Error()))
 }
 
 type execPrinter struct {
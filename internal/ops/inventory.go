@@ -0,0 +1,214 @@
+package ops
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Inventory is a dynamic source of servers, which are merged into servers of Opsfile.
+// Servers could be provided as a mapping of server name to server, same as servers
+// in Opsfile, or as a sequence of servers with name.
+type Inventory struct {
+	// Script is a local command run by sh in directory of Opsfile, which prints servers in json or yaml
+	Script string `yaml:"script"`
+	// File is a json or yaml file of servers
+	File string `yaml:"file"`
+	// Cache is how long script output to be cached, eg: 10m, not cached if empty
+	Cache   string    `yaml:"cache"`
+	servers []*Server // resolved servers
+}
+
+type inventoryServer struct {
+	Name   string `yaml:"name"`
+	Server `yaml:",inline"`
+}
+
+// Servers returns servers resolved from inventory.
+func (inv *Inventory) Servers() []*Server {
+	return inv.servers
+}
+
+// loadServers merges servers from inventory into servers of Opsfile and validates groups on
+// first call, so inventory is only resolved by commands using servers.
+func (conf *Opsfile) loadServers() error {
+	if !conf.loaded {
+		conf.loaded = true
+		if conf.loadErr = conf.LoadInventory(false); conf.loadErr == nil {
+			conf.loadErr = conf.validateGroups()
+		}
+	}
+	return conf.loadErr
+}
+
+// Server returns server named name, including servers from inventory.
+func (conf *Opsfile) Server(name string) (*Server, error) {
+	if err := conf.loadServers(); err != nil {
+		return nil, err
+	}
+	s, ok := conf.Servers.Names[name]
+	if !ok {
+		return nil, fmt.Errorf("no server named: %s", name)
+	}
+	return s, nil
+}
+
+// LoadInventory resolves servers from inventory and merges them into servers of Opsfile,
+// servers defined in Opsfile take precedence over servers from inventory. Cached script
+// output will be ignored if refresh is true.
+func (conf *Opsfile) LoadInventory(refresh bool) error {
+	inv := conf.Inventory
+	if inv == nil {
+		return nil
+	}
+	if conf.Servers == nil {
+		conf.Servers = &Servers{Names: make(map[string]*Server, 0)}
+	}
+	for _, s := range inv.servers {
+		if conf.Servers.Names[s.Name] == s {
+			conf.Servers.remove(s.Name)
+		}
+	}
+	inv.servers = nil
+	data, err := inv.read(conf.dir, refresh)
+	if err != nil {
+		return fmt.Errorf("load inventory failed: %w", err)
+	}
+	servers, err := parseInventory(data)
+	if err != nil {
+		return fmt.Errorf("parse inventory failed: %w", err)
+	}
+	inv.servers = servers
+	for _, s := range servers {
+		if _, ok := conf.Servers.Names[s.Name]; !ok {
+			conf.Servers.add(s)
+		}
+	}
+	return nil
+}
+
+func (inv *Inventory) read(dir string, refresh bool) ([]byte, error) {
+	switch {
+	case inv.Script != "" && inv.File != "":
+		return nil, fmt.Errorf("script and file are exclusive")
+	case inv.File != "":
+		return os.ReadFile(resolvePath(dir, inv.File))
+	case inv.Script != "":
+		var ttl time.Duration
+		if inv.Cache != "" {
+			d, err := time.ParseDuration(inv.Cache)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cache duration: %w", err)
+			}
+			ttl = d
+		}
+		cache := inv.cachePath(dir)
+		if ttl > 0 && !refresh && cache != "" {
+			if fi, err := os.Stat(cache); err == nil && time.Since(fi.ModTime()) < ttl {
+				if data, err := os.ReadFile(cache); err == nil {
+					return data, nil
+				}
+			}
+		}
+		data, err := inv.run(dir)
+		if err != nil {
+			return nil, err
+		}
+		if ttl > 0 && cache != "" {
+			// servers are still resolved without cache
+			if err := writeCache(cache, data); err != nil {
+				fmt.Fprintln(os.Stderr, "cache inventory failed:", err)
+			}
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("either script or file is required")
+	}
+}
+
+func writeCache(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// run runs script through sh, so arguments are quoted like in a shell.
+func (inv *Inventory) run(dir string) ([]byte, error) {
+	if strings.TrimSpace(inv.Script) == "" {
+		return nil, fmt.Errorf("empty script")
+	}
+	var stdout bytes.Buffer
+	cmd := exec.Command("sh", "-c", inv.Script)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("run script: %s failed: %w", inv.Script, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// cachePath returns path of cached script output, or empty if no cache dir available.
+func (inv *Inventory) cachePath(dir string) string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(dir + "\x00" + inv.Script))
+	return filepath.Join(cacheDir, "ops", fmt.Sprintf("inventory-%x.json", hash.Sum64()))
+}
+
+func parseInventory(data []byte) ([]*Server, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	if len(node.Content) == 0 {
+		return []*Server{}, nil
+	}
+	root := node.Content[0]
+	switch root.Kind {
+	case yaml.MappingNode:
+		var servers Servers
+		if err := root.Decode(&servers); err != nil {
+			return nil, err
+		}
+		return servers.List(), nil
+	case yaml.SequenceNode:
+		var items []inventoryServer
+		if err := root.Decode(&items); err != nil {
+			return nil, err
+		}
+		list := make([]*Server, 0, len(items))
+		for i := range items {
+			if items[i].Name == "" {
+				return nil, fmt.Errorf("yaml: line: %d server name is required", root.Content[i].Line)
+			}
+			s := items[i].Server
+			s.Name = items[i].Name
+			s.Password = strings.TrimSpace(s.Password)
+			s.SudoPassword = strings.TrimSpace(s.SudoPassword)
+			list = append(list, &s)
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("yaml: line: %d require mapping or sequence of servers", root.Line)
+	}
+}
+
+// resolvePath resolves relative path against dir.
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
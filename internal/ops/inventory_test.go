@@ -0,0 +1,101 @@
+package ops
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseInventory(t *testing.T) {
+	tests := []struct {
+		data string
+		want string // name@host:port of servers, port is 0 if unset, or error substring prefixed with !
+	}{
+		{``, ""},
+		{`{}`, ""},
+		{`[]`, ""},
+		// mapping of server name to server, same as servers of Opsfile
+		{"web-1:\n  host: 10.0.0.1\n  port: 2222\ndb-1:\n  host: 10.0.0.2\n", "web-1@10.0.0.1:2222,db-1@10.0.0.2:0"},
+		// sequence of servers with name, in json or yaml
+		{`[{"name": "web-1", "host": "10.0.0.1", "port": 2222}, {"name": "db-1", "host": "10.0.0.2"}]`, "web-1@10.0.0.1:2222,db-1@10.0.0.2:0"},
+		{"- name: web-1\n  host: 10.0.0.1\n", "web-1@10.0.0.1:0"},
+		{`[{"host": "10.0.0.1"}]`, "!server name is required"},
+		{`web-1`, "!require mapping or sequence of servers"},
+		{`[web-1]`, "!"},
+		{`{`, "!"},
+	}
+	for _, tt := range tests {
+		servers, err := parseInventory([]byte(tt.data))
+		if wantErr, ok := strings.CutPrefix(tt.want, "!"); ok {
+			if err == nil || !strings.Contains(err.Error(), wantErr) {
+				t.Errorf("parseInventory(%q) error = %v, want %q", tt.data, err, wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseInventory(%q) failed: %v", tt.data, err)
+			continue
+		}
+		got := make([]string, 0, len(servers))
+		for _, s := range servers {
+			got = append(got, s.Name+"@"+s.Host+":"+strconv.Itoa(int(s.Port)))
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("parseInventory(%q) = %s, want %s", tt.data, strings.Join(got, ","), tt.want)
+		}
+	}
+}
+
+func TestParseInventoryTrimsPasswords(t *testing.T) {
+	servers, err := parseInventory([]byte(`[{"name": "web-1", "host": "10.0.0.1", "password": "pass\n", "sudo-password": " sudo "}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := servers[0]; s.Password != "pass" || s.SudoPassword != "sudo" {
+		t.Errorf("passwords = %q, %q, want trimmed", s.Password, s.SudoPassword)
+	}
+}
+
+func TestInventoryScript(t *testing.T) {
+	dir := t.TempDir()
+	script := `echo run >> runs; printf '[{"name": "%s", "host": "10.0.0.1"}]' "$1"`
+	if err := os.WriteFile(filepath.Join(dir, "servers.sh"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := NewOpsfile([]byte(`
+inventory:
+  script: ./servers.sh "web 1"
+groups:
+  web:
+    - web 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.dir = dir
+	// inventory is resolved lazily and only once
+	if _, err := os.Stat(filepath.Join(dir, "runs")); err == nil {
+		t.Fatal("inventory should not be resolved before servers are used")
+	}
+	for i := 0; i < 2; i++ {
+		servers, err := conf.Select(&Selector{Hosts: []string{"web"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(servers) != 1 || servers[0].Name != "web 1" {
+			t.Fatalf("Select() = %v, want server named: web 1", servers)
+		}
+	}
+	if _, err := conf.Server("web 1"); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := os.ReadFile(filepath.Join(dir, "runs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(runs), "run"); n != 1 {
+		t.Errorf("script ran %d times, want 1", n)
+	}
+}
@@ -1,5 +1,18 @@
 package ops
 
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/jevi061/ops/internal/connector"
+	"github.com/jevi061/ops/internal/tunnel"
+)
+
 type Ops struct {
 	conf          *Opsfile
 	debug         bool
@@ -58,11 +71,15 @@ func (pe *ParseError) Error() string {
 }
 
 // Run
-func (ops *Ops) Run(serverTag string, tasks ...string) error {
+func (ops *Ops) Run(sel *Selector, tasks ...string) error {
 	cp := &connectorPreparer{}
-	connectors := cp.Prepare(ops.conf, serverTag)
+	connectors, err := cp.Prepare(ops.conf, sel)
+	if err != nil {
+		return err
+	}
 
 	ctp := &connectorTaskPreparer{}
+	defer ctp.Cleanup()
 	connectorTasks, err := ctp.Prepare(ops.conf, tasks...)
 	if err != nil {
 		return err
@@ -71,5 +88,98 @@ func (ops *Ops) Run(serverTag string, tasks ...string) error {
 	if err := exec.Execute(connectorTasks, connectors); err != nil {
 		return err
 	}
+	// servers skipped by connect-policy fail the run, so partial rollouts are noticed
+	if len(exec.unreachable) > 0 {
+		names := make([]string, 0, len(exec.unreachable))
+		for c := range exec.unreachable {
+			names = append(names, c.Name())
+		}
+		sort.Strings(names)
+		return fmt.Errorf("tasks are skipped on unreachable servers: %s", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// Command is an ad-hoc command to run on servers without defining a task.
+type Command struct {
+	Cmd        string
+	Dir        string
+	Become     bool
+	BecomeUser string
+	Parallel   bool
+}
+
+// Exec runs command on selected servers through a task built on the fly, it runs on all
+// of them regardless of fail-fast, and fails if it fails on any of them.
+func (ops *Ops) Exec(sel *Selector, cmd *Command) error {
+	cp := &connectorPreparer{}
+	connectors, err := cp.Prepare(ops.conf, sel)
+	if err != nil {
+		return err
+	}
+	// the local connector is always prepared
+	if len(connectors) <= 1 {
+		return errors.New("no server is selected")
+	}
+	envs := ops.conf.Environments.Envs
+	t := connector.NewCommonTask(connector.WithName("exec"),
+		connector.WithDesc(cmd.Cmd),
+		connector.WithShell(ops.conf.Shell),
+		connector.WithCommand(cmd.Cmd),
+		connector.WithEnvironments(envs),
+		connector.WithParallel(cmd.Parallel),
+		connector.WithBecome(cmd.Become, cmd.BecomeUser),
+		connector.WithDir(expandEnvs(cmd.Dir, envs)))
+	conf := *ops.conf
+	conf.FailFast = false
+	exec := NewExecutor(&conf, ops.debug, ops.dryRun, true)
+	exec.stream = true
+	if err := exec.Execute([]connector.Task{t}, connectors); err != nil {
+		return err
+	}
+	if exec.failed.Load() {
+		return errors.New("command failed on some servers")
+	}
+	return nil
+}
+
+// Connect connects to server of Opsfile.
+func (ops *Ops) Connect(server string) (*connector.SSHConnector, error) {
+	s, err := ops.conf.Server(server)
+	if err != nil {
+		return nil, err
+	}
+	c := newSSHConnector(ops.conf, s)
+	if err := c.Connect(); err != nil {
+		return nil, &ConnectError{Host: s.Host, Err: fmt.Errorf("connect to %s failed: %w", server, err)}
+	}
+	return c, nil
+}
+
+// Tunnel opens tunnels through server, and keeps them open until interrupted.
+func (ops *Ops) Tunnel(server string, specs ...tunnel.Spec) error {
+	c, err := ops.Connect(server)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for _, spec := range specs {
+		t, err := tunnel.Open(c, spec, tunnel.WithErrorHandler(func(err error) {
+			fmt.Fprintln(os.Stderr, err)
+		}))
+		if err != nil {
+			return fmt.Errorf("open tunnel: %s through %s failed: %w", spec, server, err)
+		}
+		defer t.Close()
+		if spec.Kind == tunnel.Remote {
+			fmt.Printf("Forwarding %s through %s, listening on %s of %s\n", spec, server, t.Addr(), server)
+		} else {
+			fmt.Printf("Forwarding %s through %s, listening on %s\n", spec, server, t.Addr())
+		}
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	<-signals
 	return nil
 }
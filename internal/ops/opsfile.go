@@ -1,30 +1,131 @@
 package ops
 
 import (
+	"errors"
 	"fmt"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jevi061/ops/internal/transfer"
+	"github.com/jevi061/ops/internal/tunnel"
 	"gopkg.in/yaml.v3"
 )
 
+// Orders of servers and tasks
+const (
+	OrderFile   = "file"
+	OrderSorted = "sorted"
+	OrderRandom = "random"
+)
+
+// Policies of connection failures
+const (
+	ConnectAbort           = "abort"
+	ConnectSkipUnreachable = "skip-unreachable"
+)
+
+// Backends of payload transfers
+const (
+	BackendTar  = "tar"
+	BackendSFTP = "sftp"
+)
+
+// Symlinks handling of payloads
+const (
+	SymlinksPreserve = "preserve"
+	SymlinksFollow   = "follow"
+)
+
 type Opsfile struct {
-	Shell        string        `yaml:"shell"`
-	FailFast     bool          `yaml:"fail-fast"`
-	Servers      *Servers      `yaml:"servers"`
-	Tasks        *Tasks        `yaml:"tasks"`
-	Environments *Environments `yaml:"environments"`
+	Shell         string              `yaml:"shell"`
+	FailFast      bool                `yaml:"fail-fast"`
+	Servers       *Servers            `yaml:"servers"`
+	Groups        map[string][]string `yaml:"groups"`
+	Tasks         *Tasks              `yaml:"tasks"`
+	Environments  *Environments       `yaml:"environments"`
+	Inventory     *Inventory          `yaml:"inventory"`
+	Order         string              `yaml:"order" schema:"enum=file|sorted|random"`
+	SSH           *SSHSettings        `yaml:"ssh"`
+	ConnectPolicy string              `yaml:"connect-policy" schema:"enum=abort|skip-unreachable"`
+	dir           string              // directory of Opsfile
+	loaded        bool                // servers of inventory are loaded, see loadServers
+	loadErr       error
+}
+
+// SSHSettings are settings of ssh connections to servers.
+type SSHSettings struct {
+	// ConnectTimeout is how long to wait for connecting to a server, eg: 10s, defaults to 5s
+	ConnectTimeout string `yaml:"connect-timeout"`
+	// KeepaliveInterval is interval of keepalive requests to servers, eg: 30s, disabled if empty
+	KeepaliveInterval string `yaml:"keepalive-interval"`
+	// KeepaliveCountMax is number of unanswered keepalive requests to drop a connection, defaults to 3
+	KeepaliveCountMax int `yaml:"keepalive-count-max"`
+	connectTimeout    time.Duration
+	keepaliveInterval time.Duration
+}
+
+// parse parses durations and sets default values.
+func (s *SSHSettings) parse() error {
+	s.connectTimeout = 5 * time.Second
+	if s.ConnectTimeout != "" {
+		d, err := time.ParseDuration(s.ConnectTimeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid connect-timeout: %s", s.ConnectTimeout)
+		}
+		s.connectTimeout = d
+	}
+	if s.KeepaliveInterval != "" {
+		d, err := time.ParseDuration(s.KeepaliveInterval)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid keepalive-interval: %s", s.KeepaliveInterval)
+		}
+		s.keepaliveInterval = d
+	}
+	if s.KeepaliveCountMax < 0 {
+		return fmt.Errorf("invalid keepalive-count-max: %d", s.KeepaliveCountMax)
+	}
+	if s.KeepaliveCountMax == 0 {
+		s.KeepaliveCountMax = 3
+	}
+	return nil
 }
+
+// Timeout returns how long to wait for connecting to a server.
+func (s *SSHSettings) Timeout() time.Duration {
+	return s.connectTimeout
+}
+
+// Keepalive returns interval of keepalive requests and number of unanswered ones to drop
+// a connection, keepalive is disabled if interval is zero.
+func (s *SSHSettings) Keepalive() (time.Duration, int) {
+	return s.keepaliveInterval, s.KeepaliveCountMax
+}
+
 type Servers struct {
 	Names map[string]*Server
+	order []string // server names in Opsfile order
 }
 type Server struct {
-	Host     string   `yaml:"host"`
-	Port     uint     `yaml:"port"`
-	User     string   `yaml:"user"`
-	Password string   `yaml:"password"`
-	Tags     []string `yaml:"tags"`
+	Name     string `yaml:"-"`
+	Host     string `yaml:"host"`
+	Port     uint   `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// SudoPassword answers sudo prompt of tasks to become another user, defaults to Password
+	SudoPassword string   `yaml:"sudo-password"`
+	Tags         []string `yaml:"tags"`
+	// Dir is default working directory of tasks running on server
+	Dir string `yaml:"dir"`
+	// ForwardAgent forwards local ssh agent to tasks running on server
+	ForwardAgent bool `yaml:"forward-agent"`
+	// SendEnv are names or globs of local env vars to send to server, eg: LC_*
+	SendEnv []string `yaml:"send-env"`
 }
 
 func (c *Servers) UnmarshalYAML(node *yaml.Node) error {
@@ -36,14 +137,37 @@ func (c *Servers) UnmarshalYAML(node *yaml.Node) error {
 	if err := node.Decode(&c.Names); err != nil {
 		return err
 	}
-	for _, s := range c.Names {
+	c.order = mappingKeys(node)
+	for k, s := range c.Names {
+		s.Name = k
 		s.Password = strings.TrimSpace(s.Password)
+		s.SudoPassword = strings.TrimSpace(s.SudoPassword)
 	}
 	return nil
 }
 
+// List returns servers in Opsfile order.
+func (c *Servers) List() []*Server {
+	list := make([]*Server, 0, len(c.Names))
+	for _, name := range orderedNames(c.order, c.Names) {
+		list = append(list, c.Names[name])
+	}
+	return list
+}
+
+func (c *Servers) add(s *Server) {
+	c.Names[s.Name] = s
+	c.order = append(c.order, s.Name)
+}
+
+func (c *Servers) remove(name string) {
+	delete(c.Names, name)
+	c.order = slices.DeleteFunc(c.order, func(n string) bool { return n == name })
+}
+
 type Tasks struct {
 	Names map[string]*Task
+	order []string // task names in Opsfile order
 }
 
 func (t *Tasks) UnmarshalYAML(node *yaml.Node) error {
@@ -55,14 +179,77 @@ func (t *Tasks) UnmarshalYAML(node *yaml.Node) error {
 		return err
 	}
 	t.Names = tasks
+	t.order = mappingKeys(node)
 	// setup task name
 	for k, v := range t.Names {
 		if v != nil {
 			v.Name = k
 		}
 	}
-	// validate transfer
+	// validate script and transfer
 	for k, v := range t.Names {
+		if v == nil {
+			continue
+		}
+		if v.Script != "" && (v.Cmd != "" || v.Payload != "") {
+			return fmt.Errorf("script of task: %s is exclusive with command and payload", k)
+		}
+		if (v.Sync || v.Delete || len(v.Exclude) > 0) && v.Payload == "" {
+			return fmt.Errorf("sync, delete and exclude of task: %s require payload", k)
+		}
+		if (v.Symlinks != "" || v.Owner != "" || v.Group != "" || v.Verify) && v.Payload == "" {
+			return fmt.Errorf("symlinks, owner, group and verify of task: %s require payload", k)
+		}
+		if v.Symlinks != "" && v.Symlinks != SymlinksPreserve && v.Symlinks != SymlinksFollow {
+			return fmt.Errorf("symlinks of task: %s is invalid, use %s or %s instead", k, SymlinksPreserve, SymlinksFollow)
+		}
+		if v.Compression != "" {
+			if v.Payload == "" || v.DestFile {
+				return fmt.Errorf("compression of task: %s requires payload, and is not allowed with dest-file", k)
+			}
+			if _, err := transfer.ParseCompression(v.Compression); err != nil {
+				return fmt.Errorf("invalid compression of task: %s : %w", k, err)
+			}
+		}
+		if v.Backend != "" && v.Backend != BackendTar && v.Backend != BackendSFTP {
+			return fmt.Errorf("backend of task: %s is invalid, use %s or %s instead", k, BackendTar, BackendSFTP)
+		}
+		if v.Backend == BackendSFTP {
+			if err := validateSFTP(v); err != nil {
+				return fmt.Errorf("backend of task: %s is sftp, %w", k, err)
+			}
+		}
+		if v.Parallel && v.RunOnce {
+			return fmt.Errorf("parallel of task: %s is not allowed with run-once", k)
+		}
+		if (v.Mode != "" || v.Backup) && !v.DestFile {
+			return fmt.Errorf("mode and backup of task: %s require dest-file", k)
+		}
+		if v.DestFile && (v.Payload == "" || v.Sync || len(v.Exclude) > 0 || v.Symlinks != "") {
+			return fmt.Errorf("dest-file of task: %s requires payload, and is not allowed with sync, exclude and symlinks", k)
+		}
+		if v.Mode != "" {
+			if _, err := parseMode(v.Mode); err != nil {
+				return fmt.Errorf("invalid mode of task: %s : %w", k, err)
+			}
+		}
+		if v.Delete && !v.Sync {
+			return fmt.Errorf("delete of task: %s requires sync", k)
+		}
+		if v.ShellStdin && v.Payload != "" {
+			return fmt.Errorf("shell-stdin of task: %s is not allowed with payload", k)
+		}
+		if (v.Shell != "" || v.ShellFlag != "") && v.Payload != "" {
+			return fmt.Errorf("shell and shell-flag of task: %s are not allowed with payload, which runs under sh", k)
+		}
+		for _, t := range v.Tunnels {
+			if t == nil || t.Server == "" {
+				return fmt.Errorf("tunnel of task: %s requires server", k)
+			}
+			if _, err := t.Spec(); err != nil {
+				return fmt.Errorf("invalid tunnel of task: %s : %w", k, err)
+			}
+		}
 		if v.Payload != "" {
 			if err := transfer.Validate(v.Payload); err != nil {
 				return fmt.Errorf("invalid payload of task: %s : %w", k, err)
@@ -72,15 +259,112 @@ func (t *Tasks) UnmarshalYAML(node *yaml.Node) error {
 	return nil
 }
 
+// validateSFTP validates options of task transferring payload through SFTP, which runs no
+// commands on remote.
+func validateSFTP(t *Task) error {
+	switch {
+	case t.Payload == "":
+		return errors.New("payload is required")
+	case t.Cmd != "" || t.Sync || t.Compression != "":
+		return errors.New("command, sync and compression are not allowed")
+	case t.Become || t.BecomeUser != "":
+		return errors.New("become and become-user are not allowed")
+	case t.Local:
+		return errors.New("local is not allowed")
+	}
+	for _, id := range []string{t.Owner, t.Group} {
+		if _, err := strconv.Atoi(id); id != "" && err != nil {
+			return errors.New("owner and group must be numeric ids")
+		}
+	}
+	return nil
+}
+
+// List returns tasks in Opsfile order.
+func (t *Tasks) List() []*Task {
+	list := make([]*Task, 0, len(t.Names))
+	for _, name := range orderedNames(t.order, t.Names) {
+		list = append(list, t.Names[name])
+	}
+	return list
+}
+
 type Task struct {
-	Name    string            `yaml:"name"`
-	Cmd     string            `yaml:"command"`
-	Prompt  string            `yaml:"prompt"`
-	Payload string            `yaml:"payload"`
-	Desc    string            `yaml:"desc"`
-	Local   bool              `yaml:"local"`
-	Envs    map[string]string `yaml:"environments"`
-	Deps    []string          `yaml:"dependencies"`
+	Name   string `yaml:"-"`
+	Cmd    string `yaml:"command"`
+	Script string `yaml:"script"`
+	// Shell overrides shell of Opsfile to run command with, eg: python3
+	Shell      string            `yaml:"shell"`
+	ShellFlag  string            `yaml:"shell-flag"`
+	ShellStdin bool              `yaml:"shell-stdin"`
+	Prompt     string            `yaml:"prompt"`
+	Payload    string            `yaml:"payload"`
+	Desc       string            `yaml:"desc"`
+	Local      bool              `yaml:"local"`
+	Envs       map[string]string `yaml:"environments"`
+	Deps       []string          `yaml:"dependencies"`
+	On         []string          `yaml:"on"`
+	RunOnce    bool              `yaml:"run-once"`
+	// Become runs task as BecomeUser, or root if BecomeUser is empty, through sudo
+	Become     bool   `yaml:"become"`
+	BecomeUser string `yaml:"become-user"`
+	// Dir is working directory of task, remote tasks default to dir of server
+	Dir string `yaml:"dir"`
+	// Sync only sends files differ from remote ones of payload, and deletes remote
+	// files missing in local if Delete is set
+	Sync   bool `yaml:"sync"`
+	Delete bool `yaml:"delete"`
+	// Exclude are glob patterns of files not to transfer
+	Exclude []string `yaml:"exclude"`
+	// Symlinks of payload are archived as links by preserve, or replaced by their targets by follow
+	Symlinks string `yaml:"symlinks" schema:"enum=preserve|follow"`
+	// Owner and Group of uploaded files, local ones are dropped if empty
+	Owner string `yaml:"owner"`
+	Group string `yaml:"group"`
+	// DestFile uploads a single file of payload to exactly the dest path, replacing it atomically
+	DestFile bool `yaml:"dest-file"`
+	// Mode is octal permission of uploaded file, defaults to mode of local file
+	Mode string `yaml:"mode"`
+	// Backup copies the replaced file aside before replacing it
+	Backup bool `yaml:"backup"`
+	// Verify compares sha256 sums of uploaded files with local ones after uploading
+	Verify bool `yaml:"verify"`
+	// Compression of archive to upload: none, gzip[:LEVEL] or zstd[:LEVEL], defaults to gzip
+	Compression string `yaml:"compression"`
+	// Parallel runs task on all matched servers concurrently
+	Parallel bool `yaml:"parallel"`
+	// Backend transfers payload by piping tar archive through shell, or through SFTP
+	Backend string `yaml:"backend" schema:"enum=tar|sftp"`
+	// Tunnels are open through servers while task runs
+	Tunnels []*TaskTunnel `yaml:"tunnels"`
+}
+
+// TaskTunnel is a tunnel through server, one of Local, Remote and Dynamic is set in syntax of
+// ssh flags, eg: local: 5432:localhost:5432.
+type TaskTunnel struct {
+	Server  string `yaml:"server"`
+	Local   string `yaml:"local"`
+	Remote  string `yaml:"remote"`
+	Dynamic string `yaml:"dynamic"`
+}
+
+// Spec parses spec of tunnel.
+func (t *TaskTunnel) Spec() (tunnel.Spec, error) {
+	specs := make([]tunnel.Spec, 0, 1)
+	for kind, s := range map[string]string{tunnel.Local: t.Local, tunnel.Remote: t.Remote, tunnel.Dynamic: t.Dynamic} {
+		if s == "" {
+			continue
+		}
+		spec, err := tunnel.Parse(kind, s)
+		if err != nil {
+			return spec, err
+		}
+		specs = append(specs, spec)
+	}
+	if len(specs) != 1 {
+		return tunnel.Spec{}, errors.New("exactly one of local, remote and dynamic is required")
+	}
+	return specs[0], nil
 }
 
 type Environments struct {
@@ -98,6 +382,48 @@ func (e *Environments) UnmarshalYAML(node *yaml.Node) error {
 	e.Envs = envs
 	return nil
 }
+
+// SetConnectPolicy sets policy of connection failures, empty policy means abort.
+func (conf *Opsfile) SetConnectPolicy(policy string) error {
+	switch policy {
+	case "", ConnectAbort, ConnectSkipUnreachable:
+		conf.ConnectPolicy = policy
+		return nil
+	default:
+		return fmt.Errorf("invalid connect policy: %s, use %s or %s instead", policy, ConnectAbort, ConnectSkipUnreachable)
+	}
+}
+
+// OrderedServers returns servers in order of Opsfile setting, defaults to Opsfile order.
+func (conf *Opsfile) OrderedServers() []*Server {
+	if conf.Servers == nil {
+		return []*Server{}
+	}
+	servers := conf.Servers.List()
+	switch conf.Order {
+	case OrderSorted:
+		sort.SliceStable(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+	case OrderRandom:
+		rand.Shuffle(len(servers), func(i, j int) { servers[i], servers[j] = servers[j], servers[i] })
+	}
+	return servers
+}
+
+// OrderedTasks returns tasks in order of Opsfile setting, defaults to Opsfile order.
+func (conf *Opsfile) OrderedTasks() []*Task {
+	if conf.Tasks == nil {
+		return []*Task{}
+	}
+	tasks := conf.Tasks.List()
+	switch conf.Order {
+	case OrderSorted:
+		sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].Name < tasks[j].Name })
+	case OrderRandom:
+		rand.Shuffle(len(tasks), func(i, j int) { tasks[i], tasks[j] = tasks[j], tasks[i] })
+	}
+	return tasks
+}
+
 func NewOpsfile(data []byte) (*Opsfile, error) {
 	var file Opsfile
 	// setup default values
@@ -109,6 +435,20 @@ func NewOpsfile(data []byte) (*Opsfile, error) {
 	if err := yaml.Unmarshal(data, &file); err != nil {
 		return nil, err
 	}
+	switch file.Order {
+	case "", OrderFile, OrderSorted, OrderRandom:
+	default:
+		return nil, fmt.Errorf("invalid order: %s, use %s, %s or %s instead", file.Order, OrderFile, OrderSorted, OrderRandom)
+	}
+	if err := file.SetConnectPolicy(file.ConnectPolicy); err != nil {
+		return nil, err
+	}
+	if file.SSH == nil {
+		file.SSH = &SSHSettings{}
+	}
+	if err := file.SSH.parse(); err != nil {
+		return nil, fmt.Errorf("ssh: %w", err)
+	}
 	// merge task environments
 	for _, t := range file.Tasks.Names {
 		t.Envs = mergeEnvs(file.Environments.Envs, t.Envs)
@@ -124,23 +464,24 @@ func NewOpsfileFromPath(path string) (*Opsfile, error) {
 		return nil, err
 	} else {
 		conf, err := NewOpsfile(data)
-		return conf, err
+		if err != nil {
+			return nil, err
+		}
+		conf.dir = filepath.Dir(path)
+		return conf, nil
 	}
 }
 
 func NewOpsfileFromPathAndEnvs(path string, envs map[string]string) (*Opsfile, error) {
-	if _, err := os.Stat(path); err != nil {
+	conf, err := NewOpsfileFromPath(path)
+	if err != nil {
 		return nil, err
 	}
-	if data, err := os.ReadFile(path); err != nil {
-		return nil, err
-	} else {
-		conf, err := NewOpsfile(data)
-		for _, t := range conf.Tasks.Names {
-			t.Envs = mergeEnvs(t.Envs, envs)
-		}
-		return conf, err
+	conf.Environments.Envs = mergeEnvs(conf.Environments.Envs, envs)
+	for _, t := range conf.Tasks.Names {
+		t.Envs = mergeEnvs(t.Envs, envs)
 	}
+	return conf, nil
 }
 
 func NewOpsfileFromPathAndEnvVars(path string, envVars []string) (*Opsfile, error) {
@@ -155,6 +496,35 @@ func NewOpsfileFromPathAndEnvVars(path string, envVars []string) (*Opsfile, erro
 	return NewOpsfileFromPathAndEnvs(path, envs)
 }
 
+// mappingKeys returns keys of mapping node in order.
+func mappingKeys(node *yaml.Node) []string {
+	keys := make([]string, 0, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keys = append(keys, node.Content[i].Value)
+	}
+	return keys
+}
+
+// orderedNames returns names in order, followed by sorted names missing in order.
+func orderedNames[T any](order []string, names map[string]T) []string {
+	ordered := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range order {
+		if _, ok := names[name]; ok && !seen[name] {
+			seen[name] = true
+			ordered = append(ordered, name)
+		}
+	}
+	rest := make([]string, 0)
+	for name := range names {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(ordered, rest...)
+}
+
 // mergeEnvs appliy prioritied envs to base envs
 func mergeEnvs(base, special map[string]string) map[string]string {
 	merged := make(map[string]string, 0)
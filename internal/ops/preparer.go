@@ -1,10 +1,13 @@
 package ops
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 
 	"github.com/jevi061/ops/internal/connector"
-	"github.com/jevi061/ops/internal/transfer"
 )
 
 type Preparer interface {
@@ -16,33 +19,30 @@ type connectorPreparer struct {
 
 type connectorTaskPreparer struct {
 	preparedExpandableTask map[string]int
+	cleanups               []func() error // release resources of prepared tasks, eg: cached archives
 }
 
-func (p *connectorPreparer) Prepare(conf *Opsfile, tag string) []connector.Connector {
+func (p *connectorPreparer) Prepare(conf *Opsfile, sel *Selector) ([]connector.Connector, error) {
 	localConnector := connector.NewLocalConnector()
-	if conf.Servers != nil && len(conf.Servers.Names) > 0 {
-		selectedServers := make([]*Server, 0)
-		if tag == "" {
-			for _, v := range conf.Servers.Names {
-				selectedServers = append(selectedServers, v)
-			}
-		} else {
-			for _, v := range conf.Servers.Names {
-				for _, t := range v.Tags {
-					if t == tag {
-						selectedServers = append(selectedServers, v)
-					}
-				}
-			}
-		}
-		connectors := make([]connector.Connector, len(selectedServers))
-		for i, c := range selectedServers {
-			connectors[i] = connector.NewSSHConnector(c.Host,
-				connector.WithPort(c.Port), connector.WithUser(c.User), connector.WithPassword(c.Password))
-		}
-		return append(connectors, localConnector)
+	selectedServers, err := conf.Select(sel)
+	if err != nil {
+		return nil, err
 	}
-	return []connector.Connector{localConnector}
+	connectors := make([]connector.Connector, len(selectedServers))
+	for i, c := range selectedServers {
+		connectors[i] = newSSHConnector(conf, c)
+	}
+	return append(connectors, localConnector), nil
+}
+
+// newSSHConnector returns connector to server.
+func newSSHConnector(conf *Opsfile, s *Server) *connector.SSHConnector {
+	return connector.NewSSHConnector(s.Host,
+		connector.WithAlias(s.Name), connector.WithPort(s.Port), connector.WithUser(s.User), connector.WithPassword(s.Password),
+		connector.WithSudoPassword(s.SudoPassword),
+		connector.WithForwardAgent(s.ForwardAgent), connector.WithSendEnv(s.SendEnv),
+		connector.WithConnectTimeout(conf.SSH.Timeout()), connector.WithKeepalive(conf.SSH.Keepalive()),
+		connector.WithDefaultDir(expandEnvs(s.Dir, conf.Environments.Envs)))
 }
 
 func (p *connectorTaskPreparer) Prepare(conf *Opsfile, tasks ...string) ([]connector.Task, error) {
@@ -78,33 +78,68 @@ func (p *connectorTaskPreparer) PrepareTask(conf *Opsfile, taskName string) ([]c
 			}
 		}
 		// task itself
+		if err := p.validateOn(conf, task); err != nil {
+			return nil, err
+		}
+		tunnels, err := p.tunnels(conf, task)
+		if err != nil {
+			return nil, err
+		}
+		dir := expandEnvs(task.Dir, task.Envs)
+		shell := conf.Shell
+		if task.Shell != "" {
+			shell = task.Shell
+		}
 		if task.Payload != "" { // upload task
-			absSrc, dest, err := transfer.ParsePayloadWithEnvs(task.Payload, task.Envs)
-			if err != nil {
-				return nil, fmt.Errorf("invalid payload of task: %s : %w", task.Name, err)
-			}
-			// build cmd
-			cmd := fmt.Sprintf(`tar -C %s -xvzf - `, dest)
-			stdin := transfer.PipeFile(absSrc)
-			t := connector.NewCommonTask(connector.WithName(task.Name),
+			// steps of transfer are POSIX shell commands, regardless of shell of Opsfile
+			t, err := p.prepareTransfer(task,
+				connector.WithName(task.Name),
 				connector.WithDesc(task.Desc),
-				connector.WithShell(conf.Shell),
-				connector.WithCommand(cmd, task.Cmd),
+				connector.WithShell("sh"),
 				connector.WithEnvironments(task.Envs),
-				connector.WithLocal(false),
-				connector.WithStdin(stdin),
-				connector.WithPrompt(task.Prompt))
-
+				connector.WithLocal(task.Local),
+				connector.WithPrompt(task.Prompt),
+				connector.WithOn(task.On...),
+				connector.WithRunOnce(task.RunOnce),
+				connector.WithParallel(task.Parallel),
+				connector.WithBecome(task.Become, task.BecomeUser),
+				connector.WithTunnels(tunnels...),
+				connector.WithDir(dir))
+			if err != nil {
+				return nil, err
+			}
 			tasks = append(tasks, t)
 
 		} else {
+			cmd := task.Cmd
+			if task.Script != "" {
+				path := resolvePath(conf.dir, os.Expand(task.Script, func(s string) string { return task.Envs[s] }))
+				script, err := os.ReadFile(path)
+				if err != nil {
+					return nil, fmt.Errorf("read script of task: %s failed: %w", task.Name, err)
+				}
+				cmd = string(script)
+			}
+			var stdin func() (io.Reader, error)
+			if task.ShellStdin {
+				stdin = func() (io.Reader, error) { return strings.NewReader(cmd), nil }
+			}
 			t := connector.NewCommonTask(connector.WithName(task.Name),
 				connector.WithDesc(task.Desc),
-				connector.WithShell(conf.Shell),
-				connector.WithCommand(task.Cmd),
+				connector.WithShell(shell),
+				connector.WithShellFlag(task.ShellFlag),
+				connector.WithShellStdin(task.ShellStdin),
+				connector.WithStdin(stdin),
+				connector.WithCommand(cmd),
 				connector.WithEnvironments(task.Envs),
 				connector.WithLocal(task.Local),
-				connector.WithPrompt(task.Prompt))
+				connector.WithPrompt(task.Prompt),
+				connector.WithOn(task.On...),
+				connector.WithRunOnce(task.RunOnce),
+				connector.WithParallel(task.Parallel),
+				connector.WithBecome(task.Become, task.BecomeUser),
+				connector.WithTunnels(tunnels...),
+				connector.WithDir(dir))
 			tasks = append(tasks, t)
 		}
 	} else { // invalid task
@@ -113,3 +148,61 @@ func (p *connectorTaskPreparer) PrepareTask(conf *Opsfile, taskName string) ([]c
 	return tasks, nil
 
 }
+
+// Cleanup releases resources of prepared tasks after running them.
+func (p *connectorTaskPreparer) Cleanup() error {
+	var errs []error
+	for _, cleanup := range p.cleanups {
+		errs = append(errs, cleanup())
+	}
+	return errors.Join(errs...)
+}
+
+// validateOn ensures each term in task's on field refers to a server, group or tag.
+func (p *connectorTaskPreparer) validateOn(conf *Opsfile, task *Task) error {
+	if len(task.On) > 0 && task.Local {
+		return fmt.Errorf("ParseTaskError: task: %s runs on local, on is not allowed", task.Name)
+	}
+	for _, term := range task.On {
+		if conf.validateHostTerm(term) == nil {
+			continue
+		}
+		tagged := false
+		for _, s := range conf.Servers.List() {
+			if hasTag(s, term) {
+				tagged = true
+				break
+			}
+		}
+		if !tagged {
+			return fmt.Errorf("ParseTaskError: task: %s is bound to %s, which is not a server, group or tag", task.Name, term)
+		}
+	}
+	return nil
+}
+
+// tunnels returns tunnels of task, whose servers must be defined in Opsfile.
+func (p *connectorTaskPreparer) tunnels(conf *Opsfile, task *Task) ([]connector.Tunnel, error) {
+	tunnels := make([]connector.Tunnel, 0, len(task.Tunnels))
+	for _, t := range task.Tunnels {
+		if _, ok := conf.Servers.Names[t.Server]; !ok {
+			return nil, fmt.Errorf("ParseTaskError: task: %s has tunnel through %s, which is not a server", task.Name, t.Server)
+		}
+		spec, err := t.Spec()
+		if err != nil {
+			return nil, fmt.Errorf("ParseTaskError: invalid tunnel of task: %s : %w", task.Name, err)
+		}
+		tunnels = append(tunnels, connector.Tunnel{Server: t.Server, Spec: spec})
+	}
+	return tunnels, nil
+}
+
+// expandEnvs expands variables of s using envs, variables not in envs are kept as is.
+func expandEnvs(s string, envs map[string]string) string {
+	return os.Expand(s, func(k string) string {
+		if v, ok := envs[k]; ok {
+			return v
+		}
+		return "${" + k + "}"
+	})
+}
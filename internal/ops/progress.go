@@ -0,0 +1,105 @@
+package ops
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/jevi061/ops/internal/connector"
+	"github.com/jevi061/ops/internal/transfer"
+)
+
+// progresser is implemented by task inputs which could report how much they sent, eg: transfers.
+type progresser interface {
+	// Progress returns bytes sent and total bytes to send
+	Progress() (int64, int64)
+}
+
+// progress shows progress of inputs sending to hosts as suffix of spinner.
+type progress struct {
+	mu      sync.Mutex
+	sources map[string]hostProgress // keyed by connector id
+	startAt time.Time
+}
+
+type hostProgress struct {
+	name    string // server name, hosts of servers may be the same
+	source  progresser
+	startAt time.Time
+}
+
+// track starts showing progress of source sending through connector.
+func (p *progress) track(c connector.Connector, source progresser) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.sources) == 0 {
+		p.sources = make(map[string]hostProgress)
+		p.startAt = time.Now()
+	}
+	p.sources[c.ID()] = hostProgress{name: c.Name(), source: source, startAt: time.Now()}
+}
+
+// reset stops showing progress of connector.
+func (p *progress) reset(c connector.Connector) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.sources, c.ID())
+}
+
+// update updates suffix of spinner, it's called by spinner before each frame. Progress of
+// a single host is shown with its name, otherwise sum of all hosts are shown followed by
+// a line for each host.
+func (p *progress) update(s *spinner.Spinner) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var done, total int64
+	for _, hp := range p.sources {
+		d, t := hp.source.Progress()
+		done, total = done+d, total+t
+	}
+	switch len(p.sources) {
+	case 0:
+		s.Suffix = ""
+	case 1:
+		for _, hp := range p.sources {
+			s.Suffix = " " + hp.name + " " + renderProgress(done, total, time.Since(p.startAt))
+		}
+	default:
+		lines := make([]string, 0, len(p.sources))
+		for _, hp := range p.sources {
+			d, t := hp.source.Progress()
+			lines = append(lines, fmt.Sprintf("  %s %s", hp.name, renderProgress(d, t, time.Since(hp.startAt))))
+		}
+		sort.Strings(lines)
+		s.Suffix = fmt.Sprintf(" %d hosts %s\n%s", len(p.sources), renderProgress(done, total, time.Since(p.startAt)),
+			strings.Join(lines, "\n"))
+	}
+}
+
+// renderProgress renders a progress bar with bytes sent, rate and estimated time left, eg:
+// [=========>          ]  45% 12.3 MiB/27.0 MiB 4.1 MiB/s ETA 3s
+func renderProgress(done, total int64, elapsed time.Duration) string {
+	const width = 20
+	ratio := 1.0
+	if total > 0 {
+		ratio = min(float64(done)/float64(total), 1)
+	}
+	filled := int(ratio * width)
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(done) / elapsed.Seconds()
+	}
+	eta := "--"
+	if rate > 0 {
+		eta = time.Duration(float64(total-done) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+	return fmt.Sprintf("[%s] %3.0f%% %s/%s %s/s ETA %s", bar, ratio*100,
+		transfer.FormatBytes(done), transfer.FormatBytes(total), transfer.FormatBytes(int64(rate)), eta)
+}
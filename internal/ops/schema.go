@@ -0,0 +1,110 @@
+package ops
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+const schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaProvider is implemented by Opsfile types whose yaml shape differs from
+// their go struct layout because of a custom UnmarshalYAML.
+type schemaProvider interface {
+	JSONSchema() map[string]any
+}
+
+// Schema generates the JSON Schema of Opsfile from its go types, so fields added
+// to Opsfile, Server or Task show up in the schema without extra work.
+func Schema() ([]byte, error) {
+	s := schemaOf(reflect.TypeOf(Opsfile{}))
+	s["$schema"] = schemaDraft
+	s["title"] = "Opsfile"
+	return json.MarshalIndent(s, "", "  ")
+}
+
+func (c *Servers) JSONSchema() map[string]any {
+	return map[string]any{
+		"type":                 "object",
+		"additionalProperties": schemaOf(reflect.TypeOf(Server{})),
+	}
+}
+
+func (t *Tasks) JSONSchema() map[string]any {
+	return map[string]any{
+		"type":                 "object",
+		"additionalProperties": schemaOf(reflect.TypeOf(Task{})),
+	}
+}
+
+func (e *Environments) JSONSchema() map[string]any {
+	return map[string]any{
+		"type":                 "object",
+		"additionalProperties": scalarSchema(),
+	}
+}
+
+// scalarSchema accepts any yaml scalar, as they are all decodable into strings.
+func scalarSchema() map[string]any {
+	return map[string]any{"type": []string{"string", "number", "boolean"}}
+}
+
+func schemaOf(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(reflect.TypeOf((*schemaProvider)(nil)).Elem()) {
+		return reflect.New(t).Interface().(schemaProvider).JSONSchema()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		props := make(map[string]any)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			props[name] = schemaOf(f.Type)
+			if enum, ok := schemaTag(f.Tag, "enum"); ok {
+				props[name] = map[string]any{"enum": strings.Split(enum, "|")}
+			}
+		}
+		return map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.String {
+			return map[string]any{"type": "object", "additionalProperties": scalarSchema()}
+		}
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{}
+	}
+}
+
+// schemaTag looks up value of key in schema tag, eg: `schema:"enum=file|sorted"`.
+func schemaTag(tag reflect.StructTag, key string) (string, bool) {
+	for _, kv := range strings.Split(tag.Get("schema"), ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
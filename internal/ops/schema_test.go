@@ -0,0 +1,115 @@
+package ops
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// loadSchema returns generated schema of Opsfile.
+func loadSchema(t *testing.T) map[string]any {
+	t.Helper()
+	data, err := Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+// schemaNode returns node of schema at path of keys.
+func schemaNode(t *testing.T, schema map[string]any, path string) map[string]any {
+	t.Helper()
+	node := schema
+	if path == "" {
+		return node
+	}
+	for _, key := range strings.Split(path, ".") {
+		next, ok := node[key].(map[string]any)
+		if !ok {
+			t.Fatalf("schema has no %s", path)
+		}
+		node = next
+	}
+	return node
+}
+
+// objects of Opsfile, servers, tasks and tunnels in schema
+var schemaObjects = []struct {
+	path string
+	keys []string
+}{
+	{"", []string{"shell", "fail-fast", "servers", "groups", "tasks", "environments", "inventory", "order", "ssh", "connect-policy"}},
+	{"properties.servers.additionalProperties", []string{"host", "port", "user", "password", "sudo-password", "tags", "dir", "forward-agent", "send-env"}},
+	{"properties.tasks.additionalProperties", []string{"command", "script", "shell", "payload", "local", "environments", "dependencies", "on", "become", "become-user", "dir", "sync", "delete", "exclude", "symlinks", "owner", "group", "dest-file", "mode", "verify", "compression", "parallel", "backend", "tunnels"}},
+	{"properties.tasks.additionalProperties.properties.tunnels.items", []string{"server", "local", "remote", "dynamic"}},
+	{"properties.ssh", []string{"connect-timeout", "keepalive-interval", "keepalive-count-max"}},
+	{"properties.inventory", []string{"script", "file", "cache"}},
+}
+
+func TestSchemaKeys(t *testing.T) {
+	schema := loadSchema(t)
+	if schema["$schema"] != schemaDraft {
+		t.Errorf("$schema = %v, want %s", schema["$schema"], schemaDraft)
+	}
+	for _, obj := range schemaObjects {
+		props, ok := schemaNode(t, schema, obj.path)["properties"].(map[string]any)
+		if !ok {
+			t.Fatalf("schema at %q has no properties", obj.path)
+		}
+		for _, key := range obj.keys {
+			if _, ok := props[key]; !ok {
+				t.Errorf("schema at %q has no key: %s", obj.path, key)
+			}
+		}
+	}
+	// names of servers and tasks are keys of their mappings
+	for _, path := range []string{"properties.servers.additionalProperties.properties", "properties.tasks.additionalProperties.properties"} {
+		if _, ok := schemaNode(t, schema, path)["name"]; ok {
+			t.Errorf("schema at %q should not have key: name", path)
+		}
+	}
+}
+
+func TestSchemaRejectsUnknownKeys(t *testing.T) {
+	schema := loadSchema(t)
+	for _, obj := range schemaObjects {
+		if v := schemaNode(t, schema, obj.path)["additionalProperties"]; v != false {
+			t.Errorf("schema at %q should reject unknown keys, additionalProperties = %v", obj.path, v)
+		}
+	}
+	// servers, tasks and environments are mappings of any names
+	for _, path := range []string{"properties.servers", "properties.tasks", "properties.environments", "properties.groups"} {
+		if _, ok := schemaNode(t, schema, path)["additionalProperties"].(map[string]any); !ok {
+			t.Errorf("schema at %q should accept any names", path)
+		}
+	}
+}
+
+func TestSchemaEnums(t *testing.T) {
+	schema := loadSchema(t)
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"properties.order", []string{"file", "sorted", "random"}},
+		{"properties.connect-policy", []string{"abort", "skip-unreachable"}},
+		{"properties.tasks.additionalProperties.properties.symlinks", []string{"preserve", "follow"}},
+		{"properties.tasks.additionalProperties.properties.backend", []string{"tar", "sftp"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		list, _ := schemaNode(t, schema, tt.path)["enum"].([]any)
+		for _, v := range list {
+			s, _ := v.(string)
+			got = append(got, s)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("enum of %s = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package ops
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Selector selects servers defined in Opsfile. An empty selector selects all servers.
+type Selector struct {
+	// Hosts are server names, globs on server names or group names, separated by comma.
+	// A term prefixed with ! excludes matched servers.
+	Hosts []string
+	// Tags are tag expressions which must all match, eg: web|api, !canary.
+	Tags []string
+}
+
+func (s *Selector) Empty() bool {
+	return s == nil || (len(s.Hosts) == 0 && len(s.Tags) == 0)
+}
+
+// Select resolves selector to servers of Opsfile.
+func (conf *Opsfile) Select(sel *Selector) ([]*Server, error) {
+	if err := conf.loadServers(); err != nil {
+		return nil, err
+	}
+	selected := make([]*Server, 0)
+	if conf.Servers == nil {
+		return selected, nil
+	}
+	var includes, excludes []string
+	if sel != nil {
+		for _, h := range sel.Hosts {
+			for _, term := range strings.Split(h, ",") {
+				term = strings.TrimSpace(term)
+				if term == "" {
+					continue
+				}
+				if strings.HasPrefix(term, "!") {
+					excludes = append(excludes, strings.TrimPrefix(term, "!"))
+				} else {
+					includes = append(includes, term)
+				}
+			}
+		}
+	}
+	for _, term := range append(includes, excludes...) {
+		if err := conf.validateHostTerm(term); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range conf.OrderedServers() {
+		if len(includes) > 0 && !conf.matchAnyHost(s, includes) {
+			continue
+		}
+		if conf.matchAnyHost(s, excludes) {
+			continue
+		}
+		if sel != nil && !matchTags(s, sel.Tags) {
+			continue
+		}
+		selected = append(selected, s)
+	}
+	return selected, nil
+}
+
+// matchHost reports whether server is matched by name, glob of name or group.
+func (conf *Opsfile) matchHost(s *Server, term string) bool {
+	if matchGlob(term, s.Name) {
+		return true
+	}
+	if members, ok := conf.Groups[term]; ok {
+		for _, m := range members {
+			if matchGlob(m, s.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchOn reports whether server is matched by any of server names, groups or tags.
+func (conf *Opsfile) matchOn(s *Server, on []string) bool {
+	for _, term := range on {
+		if conf.matchHost(s, term) || hasTag(s, term) {
+			return true
+		}
+	}
+	return false
+}
+
+func (conf *Opsfile) matchAnyHost(s *Server, terms []string) bool {
+	for _, term := range terms {
+		if conf.matchHost(s, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateGroups rejects members of groups which are neither globs nor names of servers,
+// including servers from inventory, so typos do not select nothing silently.
+func (conf *Opsfile) validateGroups() error {
+	names := make([]string, 0, len(conf.Groups))
+	for name := range conf.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, m := range conf.Groups[name] {
+			if isGlob(m) {
+				continue
+			}
+			if conf.Servers == nil || conf.Servers.Names[m] == nil {
+				return fmt.Errorf("group: %s has no server named: %s", name, m)
+			}
+		}
+	}
+	return nil
+}
+
+// validateHostTerm rejects plain host terms which neither name a server nor a group,
+// globs are allowed to match nothing.
+func (conf *Opsfile) validateHostTerm(term string) error {
+	if isGlob(term) {
+		return nil
+	}
+	if _, ok := conf.Groups[term]; ok {
+		return nil
+	}
+	if _, ok := conf.Servers.Names[term]; ok {
+		return nil
+	}
+	return fmt.Errorf("no server or group named: %s", term)
+}
+
+// matchTags reports whether server satisfies all tag expressions.
+func matchTags(s *Server, exprs []string) bool {
+	for _, expr := range exprs {
+		if !matchTagExpr(s, expr) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchTagExpr matches tag expression like web|api or !canary against tags of server.
+func matchTagExpr(s *Server, expr string) bool {
+	for _, alt := range strings.Split(expr, "|") {
+		alt = strings.TrimSpace(alt)
+		negate := strings.HasPrefix(alt, "!")
+		alt = strings.TrimPrefix(alt, "!")
+		if alt == "" {
+			continue
+		}
+		if hasTag(s, alt) != negate {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTag(s *Server, pattern string) bool {
+	for _, t := range s.Tags {
+		if matchGlob(pattern, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+func matchGlob(pattern, name string) bool {
+	if !isGlob(pattern) {
+		return pattern == name
+	}
+	ok, err := path.Match(pattern, name)
+	return err == nil && ok
+}
@@ -0,0 +1,115 @@
+package ops
+
+import (
+	"strings"
+	"testing"
+)
+
+const selectorOpsfile = `
+servers:
+  web-1:
+    host: 10.0.0.1
+    tags: [web, eu]
+  web-2:
+    host: 10.0.0.2
+    tags: [web, us, canary]
+  api-1:
+    host: 10.0.0.3
+    tags: [api, eu]
+  db-1:
+    host: 10.0.0.4
+    tags: [db, eu]
+groups:
+  frontend:
+    - web-*
+    - api-1
+  backend:
+    - db-1
+`
+
+func TestSelect(t *testing.T) {
+	conf, err := NewOpsfile([]byte(selectorOpsfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		sel  *Selector
+		want string
+	}{
+		{nil, "web-1,web-2,api-1,db-1"},
+		{&Selector{}, "web-1,web-2,api-1,db-1"},
+		// names, globs and groups
+		{&Selector{Hosts: []string{"web-1"}}, "web-1"},
+		{&Selector{Hosts: []string{"web-*"}}, "web-1,web-2"},
+		{&Selector{Hosts: []string{"frontend"}}, "web-1,web-2,api-1"},
+		{&Selector{Hosts: []string{"backend,api-1"}}, "api-1,db-1"},
+		{&Selector{Hosts: []string{"frontend", "db-1"}}, "web-1,web-2,api-1,db-1"},
+		{&Selector{Hosts: []string{"nothing-*"}}, ""},
+		// exclusions
+		{&Selector{Hosts: []string{"frontend,!web-2"}}, "web-1,api-1"},
+		{&Selector{Hosts: []string{"!frontend"}}, "db-1"},
+		{&Selector{Hosts: []string{"!web-*", "!db-1"}}, "api-1"},
+		// tags are globs, repeated tags must all match
+		{&Selector{Tags: []string{"web"}}, "web-1,web-2"},
+		{&Selector{Tags: []string{"web", "eu"}}, "web-1"},
+		{&Selector{Tags: []string{"e*"}}, "web-1,api-1,db-1"},
+		// any alternative of expression matches
+		{&Selector{Tags: []string{"web|api"}}, "web-1,web-2,api-1"},
+		{&Selector{Tags: []string{"web|api", "!canary"}}, "web-1,api-1"},
+		{&Selector{Tags: []string{"!eu"}}, "web-2"},
+		{&Selector{Tags: []string{"db|!eu"}}, "web-2,db-1"},
+		{&Selector{Tags: []string{"nothing"}}, ""},
+		// hosts and tags must both match
+		{&Selector{Hosts: []string{"frontend"}, Tags: []string{"eu"}}, "web-1,api-1"},
+	}
+	for _, tt := range tests {
+		servers, err := conf.Select(tt.sel)
+		if err != nil {
+			t.Errorf("Select(%+v) failed: %v", tt.sel, err)
+			continue
+		}
+		names := make([]string, 0, len(servers))
+		for _, s := range servers {
+			names = append(names, s.Name)
+		}
+		if got := strings.Join(names, ","); got != tt.want {
+			t.Errorf("Select(%+v) = %s, want %s", tt.sel, got, tt.want)
+		}
+	}
+}
+
+func TestSelectUnknownNames(t *testing.T) {
+	conf, err := NewOpsfile([]byte(selectorOpsfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hosts := range []string{"web-3", "frontend,web-3", "!web-3", "frontnd"} {
+		if _, err := conf.Select(&Selector{Hosts: []string{hosts}}); err == nil {
+			t.Errorf("Select(%s) should fail", hosts)
+		}
+	}
+}
+
+func TestValidateGroups(t *testing.T) {
+	conf, err := NewOpsfile([]byte(selectorOpsfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conf.validateGroups(); err != nil {
+		t.Fatalf("validateGroups() failed: %v", err)
+	}
+	conf.Groups["typo"] = []string{"web-1", "db-01"}
+	err = conf.validateGroups()
+	if err == nil || !strings.Contains(err.Error(), "db-01") {
+		t.Fatalf("validateGroups() = %v, want error of db-01", err)
+	}
+	// members could be servers from inventory
+	servers, err := parseInventory([]byte(`[{name: db-01, host: 10.0.0.5}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Servers.add(servers[0])
+	if err := conf.validateGroups(); err != nil {
+		t.Fatalf("validateGroups() with inventory server failed: %v", err)
+	}
+}
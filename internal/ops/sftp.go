@@ -0,0 +1,205 @@
+package ops
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/jevi061/ops/internal/connector"
+	"github.com/jevi061/ops/internal/transfer"
+	"github.com/pkg/sftp"
+)
+
+// sftpConnector is implemented by connectors which could open SFTP sessions.
+type sftpConnector interface {
+	SFTP() (*sftp.Client, error)
+}
+
+// prepareSFTP prepares upload task of payload through SFTP, which needs no shell or tar on remote.
+// Directories are sent as an uncompressed tar archive, whose entries are replicated into destination.
+func (p *connectorTaskPreparer) prepareSFTP(task *Task, absSrc, dest string, options ...func(*connector.CommonTask)) (connector.Task, error) {
+	dest = sftpPath(expandEnvs(task.Dir, task.Envs), dest)
+	// there is no remote shell to expand variables not in environments
+	if strings.Contains(dest, "${") {
+		return nil, fmt.Errorf("destination of task: %s refers to variables not in environments: %s, which sftp backend could not expand", task.Name, dest)
+	}
+	var owner *transfer.Owner
+	if task.Owner != "" || task.Group != "" {
+		owner = &transfer.Owner{User: task.Owner, Group: task.Group}
+	}
+	if task.DestFile {
+		return p.prepareSFTPFile(task, absSrc, dest, owner, options...)
+	}
+	ignore, err := transfer.LoadIgnore(absSrc, task.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclude of task: %s : %w", task.Name, err)
+	}
+	// local files are walked once for all connectors when the task runs, and checksummed if verified
+	var (
+		once    sync.Once
+		files   []transfer.File
+		walkErr error
+	)
+	local := func() ([]transfer.File, error) {
+		once.Do(func() {
+			if files, walkErr = transfer.Walk(absSrc, ignore, task.Symlinks == SymlinksFollow); walkErr == nil && task.Verify {
+				walkErr = transfer.Checksum(files)
+			}
+		})
+		if walkErr != nil {
+			return nil, fmt.Errorf("walk source of task: %s failed: %w", task.Name, walkErr)
+		}
+		return files, nil
+	}
+	action := func(c connector.Connector, stdin io.Reader, stdout io.Writer) error {
+		client, err := sftpClient(c)
+		if err != nil {
+			return err
+		}
+		return transfer.ExtractSFTP(client, stdin, dest, owner)
+	}
+	var verifier func(connector.Connector) error
+	if task.Verify {
+		verifier = func(c connector.Connector) error {
+			files, err := local()
+			if err != nil {
+				return err
+			}
+			client, err := sftpClient(c)
+			if err != nil {
+				return err
+			}
+			remote := make(map[string]string)
+			for _, f := range files {
+				if !f.Regular() {
+					continue
+				}
+				if remote[f.Name], err = transfer.SumSFTP(client, path.Join(dest, f.Name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return fmt.Errorf("read %s on %s failed: %w", f.Name, c.Name(), err)
+				}
+			}
+			return transfer.Verify(files, remote)
+		}
+	}
+	return sftpTask(absSrc, dest, append(options,
+		connector.WithStdin(func() (io.Reader, error) {
+			files, err := local()
+			if err != nil {
+				return nil, err
+			}
+			return transfer.Archive(files, owner, transfer.Compression{Codec: transfer.CodecNone})()
+		}),
+		connector.WithAction(action),
+		connector.WithVerifier(verifier))...), nil
+}
+
+// prepareSFTPFile prepares upload task of a single file to exactly dest through SFTP, the file is
+// written to a partial file beside dest, which is resumed by later runs if uploading is broken.
+func (p *connectorTaskPreparer) prepareSFTPFile(task *Task, src, dest string, owner *transfer.Owner, options ...func(*connector.CommonTask)) (connector.Task, error) {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload of task: %s : %w", task.Name, err)
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("invalid payload of task: %s : %s is not a regular file", task.Name, src)
+	}
+	opts := transfer.PutOptions{Size: fi.Size(), Mode: fi.Mode().Perm(), Owner: owner, Backup: task.Backup}
+	if task.Mode != "" {
+		if opts.Mode, err = parseMode(task.Mode); err != nil {
+			return nil, fmt.Errorf("invalid mode of task: %s : %w", task.Name, err)
+		}
+		// os.FileMode keeps special bits apart from permission bits
+		opts.Mode = opts.Mode&os.ModePerm | specialBits(opts.Mode)
+	}
+	// partial file is named by size and modification time of source, so changed source is not resumed
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d-%d", fi.Size(), fi.ModTime().UnixNano())
+	opts.Part = fmt.Sprintf(".%s.ops-%x.part", path.Base(dest), h.Sum64())
+	action := func(c connector.Connector, stdin io.Reader, stdout io.Writer) error {
+		client, err := sftpClient(c)
+		if err != nil {
+			return err
+		}
+		return transfer.PutSFTP(client, stdin, dest, opts)
+	}
+	var verifier func(connector.Connector) error
+	if task.Verify {
+		verifier = func(c connector.Connector) error {
+			files := []transfer.File{{Path: src, Name: dest, Info: fi}}
+			if err := transfer.Checksum(files); err != nil {
+				return err
+			}
+			client, err := sftpClient(c)
+			if err != nil {
+				return err
+			}
+			sum, err := transfer.SumSFTP(client, dest)
+			if err != nil {
+				return fmt.Errorf("read %s on %s failed: %w", dest, c.Name(), err)
+			}
+			return transfer.Verify(files, map[string]string{dest: sum})
+		}
+	}
+	return sftpTask(src, dest, append(options,
+		connector.WithStdin(transfer.Copy(src)),
+		connector.WithAction(action),
+		connector.WithVerifier(verifier))...), nil
+}
+
+// sftpTask returns SFTP upload task, which prints what is uploaded in debug or dry run mode,
+// as it runs no commands to print.
+func sftpTask(src, dest string, options ...func(*connector.CommonTask)) connector.Task {
+	t := connector.NewCommonTask(options...)
+	connector.WithBinder(func(c connector.Connector, opts *connector.RunOptions) (connector.Task, error) {
+		if opts.Debug || opts.DryRun {
+			fmt.Printf("%ssftp put %s -> %s\n", c.Promet(), src, dest)
+		}
+		return t, nil
+	})(t)
+	return t
+}
+
+// sftpClient returns SFTP client of connector.
+func sftpClient(c connector.Connector) (*sftp.Client, error) {
+	sc, ok := c.(sftpConnector)
+	if !ok {
+		return nil, fmt.Errorf("sftp is not supported on %s", c.Name())
+	}
+	return sc.SFTP()
+}
+
+// sftpPath resolves remote path of SFTP relative to dir, paths of SFTP are relative to home
+// of login user, so a leading ~ is dropped.
+func sftpPath(dir, p string) string {
+	home := func(p string) string {
+		if p == "~" {
+			return "."
+		}
+		return strings.TrimPrefix(p, "~/")
+	}
+	p = home(p)
+	if dir != "" && !path.IsAbs(p) {
+		p = path.Join(home(dir), p)
+	}
+	return p
+}
+
+// specialBits converts setuid, setgid and sticky bits of unix permission to os.FileMode.
+func specialBits(mode os.FileMode) os.FileMode {
+	var bits os.FileMode
+	if mode&0o4000 != 0 {
+		bits |= os.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		bits |= os.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		bits |= os.ModeSticky
+	}
+	return bits
+}
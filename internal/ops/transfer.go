@@ -0,0 +1,304 @@
+package ops
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jevi061/ops/internal/connector"
+	"github.com/jevi061/ops/internal/shellquote"
+	"github.com/jevi061/ops/internal/transfer"
+)
+
+// prepareTransfer prepares upload task of payload, which extracts a tar archive of source
+// into destination, command of task runs after extracting in the same session.
+func (p *connectorTaskPreparer) prepareTransfer(task *Task, options ...func(*connector.CommonTask)) (connector.Task, error) {
+	absSrc, dest, err := transfer.ParsePayloadWithEnvs(task.Payload, task.Envs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload of task: %s : %w", task.Name, err)
+	}
+	if task.Backend == BackendSFTP {
+		return p.prepareSFTP(task, absSrc, dest, options...)
+	}
+	if task.DestFile {
+		return p.prepareFile(task, absSrc, dest, options...)
+	}
+	ignore, err := transfer.LoadIgnore(absSrc, task.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclude of task: %s : %w", task.Name, err)
+	}
+	follow := task.Symlinks == SymlinksFollow
+	comp, err := transfer.ParseCompression(task.Compression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compression of task: %s : %w", task.Name, err)
+	}
+	var owner *transfer.Owner
+	if task.Owner != "" || task.Group != "" {
+		owner = &transfer.Owner{User: task.Owner, Group: task.Group}
+	}
+	root := filepath.Base(absSrc)
+	extract := extractCommand(dest, root, comp, owner, task.BecomeUser)
+	// local files are walked once for all connectors, and checksummed if they are compared with remote ones
+	var (
+		once    sync.Once
+		files   []transfer.File
+		walkErr error
+	)
+	local := func() ([]transfer.File, error) {
+		once.Do(func() {
+			if files, walkErr = transfer.Walk(absSrc, ignore, follow); walkErr == nil && (task.Sync || task.Verify) {
+				walkErr = transfer.Checksum(files)
+			}
+		})
+		return files, walkErr
+	}
+	var verifier func(connector.Connector) error
+	if task.Verify {
+		verifier = func(c connector.Connector) error {
+			files, err := local()
+			if err != nil {
+				return fmt.Errorf("walk source of task: %s failed: %w", task.Name, err)
+			}
+			remote, err := remoteSums(c, transfer.ListCommand(dest, root), options)
+			if err != nil {
+				return err
+			}
+			return transfer.Verify(files, remote)
+		}
+	}
+	if !task.Sync {
+		// archive is built once and streamed to all connectors
+		cache := transfer.NewCache(func() (io.Reader, error) {
+			files, err := local()
+			if err != nil {
+				return nil, err
+			}
+			return transfer.Archive(files, owner, comp)()
+		})
+		p.cleanups = append(p.cleanups, cache.Close)
+		command := transferScript([]string{extract}, task.Cmd)
+		return connector.NewCommonTask(append(options,
+			connector.WithCommand(command),
+			connector.WithBinder(func(c connector.Connector, opts *connector.RunOptions) (connector.Task, error) {
+				return connector.NewCommonTask(append(options,
+					connector.WithCommand(command),
+					connector.WithStdin(cache.Opener(opts.Targets)),
+					connector.WithVerifier(verifier))...), nil
+			}))...), nil
+	}
+	binder := func(c connector.Connector, opts *connector.RunOptions) (connector.Task, error) {
+		files, err := local()
+		if err != nil {
+			return nil, fmt.Errorf("walk source of task: %s failed: %w", task.Name, err)
+		}
+		remote, err := remoteSums(c, transfer.ListCommand(dest, root), options)
+		if err != nil {
+			return nil, err
+		}
+		plan := transfer.Plan(files, remote, task.Delete, ignore)
+		if opts.Debug || opts.DryRun {
+			for _, line := range strings.Split(strings.TrimSuffix(plan.String(), "\n"), "\n") {
+				fmt.Printf("%s%s\n", c.Promet(), line)
+			}
+		}
+		steps := make([]string, 0)
+		var stdin func() (io.Reader, error)
+		if sent := plan.Files(); len(sent) > 0 {
+			steps = append(steps, extract)
+			stdin = transfer.Archive(sent, owner, comp)
+		}
+		if len(plan.Deleted) > 0 || len(plan.DeletedDirs) > 0 {
+			deletes := []string{"cd -- " + shellquote.Path(dest)}
+			if len(plan.Deleted) > 0 {
+				deletes = append(deletes, "rm -f -- "+shellquote.Join(plan.Deleted...))
+			}
+			if len(plan.DeletedDirs) > 0 {
+				// directories still holding excluded files are kept
+				deletes = append(deletes, fmt.Sprintf("{ rmdir -- %s 2>/dev/null; true; }", shellquote.Join(plan.DeletedDirs...)))
+			}
+			steps = append(steps, strings.Join(deletes, " && "))
+		}
+		if len(steps) == 0 && task.Cmd == "" {
+			steps = append(steps, "true")
+		}
+		return connector.NewCommonTask(append(options,
+			connector.WithCommand(transferScript(steps, task.Cmd)),
+			connector.WithStdin(stdin),
+			connector.WithVerifier(verifier))...), nil
+	}
+	return connector.NewCommonTask(append(options,
+		connector.WithCommand(extract),
+		connector.WithBinder(binder))...), nil
+}
+
+// extractCommand returns command extracting archive of root compressed by comp from stdin into dest.
+// Modes are always kept, ownership is applied only if mapped by owner, which requires root on remote.
+// Unset user or group of owner is the one of remote user, see remoteOwner.
+func extractCommand(dest, root string, comp transfer.Compression, owner *transfer.Owner, becomeUser string) string {
+	flags, decompress := "-xv", ""
+	switch comp.Codec {
+	case transfer.CodecGzip:
+		flags += "z"
+	case transfer.CodecZstd:
+		decompress = "zstd -dcq | "
+	}
+	if owner == nil {
+		return fmt.Sprintf(`%star -C %s %spof -`, decompress, shellquote.Path(dest), flags)
+	}
+	extract := fmt.Sprintf(`%star -C %s %spf - --same-owner`, decompress, shellquote.Path(dest), flags)
+	// tar falls back to root for unknown user and group names, fail instead
+	if _, err := strconv.Atoi(owner.Group); owner.Group != "" && err != nil {
+		group := shellquote.Quote(owner.Group)
+		extract = fmt.Sprintf("{ getent group %s >/dev/null || { echo unknown group: %s >&2; false; }; } && %s", group, group, extract)
+	}
+	if _, err := strconv.Atoi(owner.User); owner.User != "" && err != nil {
+		extract = fmt.Sprintf("id -u %s >/dev/null && %s", shellquote.Quote(owner.User), extract)
+	}
+	// files extracted with unset user or group are given the one of remote user
+	extracted := shellquote.Path(path.Join(dest, root))
+	uid, gid := remoteOwner(becomeUser)
+	if owner.User == "" {
+		extract += fmt.Sprintf(` && find %s -uid %d -exec chown -h %s {} +`, extracted, transfer.UnsetID, uid)
+	}
+	if owner.Group == "" {
+		extract += fmt.Sprintf(` && find %s -gid %d -exec chgrp -h %s {} +`, extracted, transfer.UnsetID, gid)
+	}
+	return extract
+}
+
+// remoteOwner returns ids of remote user as shell words, who owns files written by task without
+// owner. It's the become user, or the login user if task becomes root, as files are meant to be
+// owned by root only if owner says so.
+func remoteOwner(becomeUser string) (string, string) {
+	if becomeUser != "" && becomeUser != "root" {
+		return `"$(id -u)"`, `"$(id -g)"`
+	}
+	return `"${SUDO_UID:-$(id -u)}"`, `"${SUDO_GID:-$(id -g)}"`
+}
+
+// prepareFile prepares upload task of a single file to exactly dest, the file is written to a
+// temporary file beside dest, which then replaces dest.
+func (p *connectorTaskPreparer) prepareFile(task *Task, src, dest string, options ...func(*connector.CommonTask)) (connector.Task, error) {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload of task: %s : %w", task.Name, err)
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("invalid payload of task: %s : %s is not a regular file", task.Name, src)
+	}
+	mode := fi.Mode().Perm()
+	if task.Mode != "" {
+		if mode, err = parseMode(task.Mode); err != nil {
+			return nil, fmt.Errorf("invalid mode of task: %s : %w", task.Name, err)
+		}
+	}
+	steps := []string{
+		`tmp=$(mktemp "$dest.XXXXXX")`,
+		`cat > "$tmp"`,
+		fmt.Sprintf(`chmod %o "$tmp"`, mode),
+	}
+	if task.Owner != "" || task.Group != "" {
+		// unset user or group is the one of remote user, like files extracted from archives
+		user, group := remoteOwner(task.BecomeUser)
+		if task.Owner != "" {
+			user = shellquote.Quote(task.Owner)
+		}
+		if task.Group != "" {
+			group = shellquote.Quote(task.Group)
+		}
+		steps = append(steps, fmt.Sprintf(`chown %s:%s "$tmp"`, user, group))
+	}
+	if task.Backup {
+		steps = append(steps, `{ [ ! -e "$dest" ] || cp -p -- "$dest" "$dest.bak-$(date +%Y%m%d%H%M%S)"; }`)
+	}
+	steps = append(steps, `mv -f -- "$tmp" "$dest"`)
+	// temporary file is removed if any step fails
+	place := fmt.Sprintf(`{ dest=%s; %s || { rm -f -- "$tmp"; exit 1; }; }`, shellquote.Path(dest), strings.Join(steps, " && "))
+	var verifier func(connector.Connector) error
+	if task.Verify {
+		verifier = func(c connector.Connector) error {
+			// sum of stdin is named -
+			files := []transfer.File{{Path: src, Name: "-", Info: fi}}
+			if err := transfer.Checksum(files); err != nil {
+				return err
+			}
+			remote, err := remoteSums(c, "sha256sum < "+shellquote.Path(dest), options)
+			if err != nil {
+				return err
+			}
+			return transfer.Verify(files, remote)
+		}
+	}
+	return connector.NewCommonTask(append(options,
+		connector.WithCommand(transferScript([]string{place}, task.Cmd)),
+		connector.WithStdin(transfer.Copy(src)),
+		connector.WithVerifier(verifier))...), nil
+}
+
+// remoteSums runs list command of sha256 sums on connector like the task of options, so files
+// are listed by the same user in the same working directory as they are written.
+func remoteSums(c connector.Connector, list string, options []func(*connector.CommonTask)) (map[string]string, error) {
+	// with input, become runs without terminal which would mix prompts into output
+	t := connector.NewCommonTask(append(options, connector.WithCommand(list),
+		connector.WithStdin(func() (io.Reader, error) { return strings.NewReader(""), nil }))...)
+	out, err := taskOutput(c, t)
+	if err != nil {
+		return nil, fmt.Errorf("list checksums of files on %s failed: %w", c.Name(), err)
+	}
+	sums, err := transfer.ParseSums(out)
+	if err != nil {
+		return nil, fmt.Errorf("list checksums of files on %s failed: %w", c.Name(), err)
+	}
+	return sums, nil
+}
+
+// taskOutput runs t through c and returns its stdout, stderr is returned within error if it fails.
+func taskOutput(c connector.Connector, t connector.Task) ([]byte, error) {
+	if err := c.Run(t, &connector.RunOptions{}); err != nil {
+		return nil, err
+	}
+	c.Stdin().Close()
+	var stdout, stderr bytes.Buffer
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		io.Copy(&stderr, c.Stderr())
+	}()
+	io.Copy(&stdout, c.Stdout())
+	wg.Wait()
+	if err := c.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// parseMode parses octal permission bits, eg: 0644.
+func parseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o7777 {
+		return 0, fmt.Errorf("%s is not an octal permission", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// transferScript chains steps of transfer, and runs cmd after them if all steps succeed.
+func transferScript(steps []string, cmd string) string {
+	script := strings.Join(steps, " && ")
+	if cmd == "" {
+		return script
+	}
+	if script == "" {
+		return cmd
+	}
+	return fmt.Sprintf("{ %s; } || exit\n%s", script, cmd)
+}
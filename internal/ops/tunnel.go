@@ -0,0 +1,85 @@
+package ops
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jevi061/ops/internal/connector"
+	"github.com/jevi061/ops/internal/tunnel"
+)
+
+// tunnels opens tunnels of tasks, connections to their servers are kept for later tasks.
+type tunnels struct {
+	conf  *Opsfile
+	conns map[string]*connector.SSHConnector // connected servers by name
+	open  []*tunnel.Tunnel                   // tunnels of running task
+}
+
+func newTunnels(conf *Opsfile) *tunnels {
+	return &tunnels{conf: conf, conns: make(map[string]*connector.SSHConnector)}
+}
+
+// Open opens tunnels of task, which are kept open until CloseTunnels.
+func (ts *tunnels) Open(t connector.Task, opts *connector.RunOptions) error {
+	for _, tn := range t.Tunnels() {
+		if opts.Debug || opts.DryRun {
+			fmt.Printf("tunnel %s through %s\n", tn.Spec, tn.Server)
+		}
+		if opts.DryRun {
+			continue
+		}
+		c, err := ts.connect(tn.Server)
+		if err != nil {
+			ts.CloseTunnels()
+			return err
+		}
+		opened, err := tunnel.Open(c, tn.Spec, tunnel.WithErrorHandler(func(err error) {
+			if opts.Debug {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}))
+		if err != nil {
+			ts.CloseTunnels()
+			return fmt.Errorf("open tunnel: %s of task: %s through %s failed: %w", tn.Spec, t.Name(), tn.Server, err)
+		}
+		ts.open = append(ts.open, opened)
+	}
+	return nil
+}
+
+// connect returns connection to server, which is connected on first call.
+func (ts *tunnels) connect(server string) (*connector.SSHConnector, error) {
+	if c, ok := ts.conns[server]; ok {
+		return c, nil
+	}
+	s, ok := ts.conf.Servers.Names[server]
+	if !ok {
+		return nil, fmt.Errorf("no server named: %s", server)
+	}
+	c := newSSHConnector(ts.conf, s)
+	if err := c.Connect(); err != nil {
+		return nil, &ConnectError{Host: s.Host, Err: fmt.Errorf("connect to %s for tunnels failed: %w", server, err)}
+	}
+	ts.conns[server] = c
+	return c, nil
+}
+
+// CloseTunnels closes tunnels opened for running task.
+func (ts *tunnels) CloseTunnels() error {
+	var errs []error
+	for _, t := range ts.open {
+		errs = append(errs, t.Close())
+	}
+	ts.open = nil
+	return errors.Join(errs...)
+}
+
+// Close closes tunnels and connections to their servers.
+func (ts *tunnels) Close() error {
+	errs := []error{ts.CloseTunnels()}
+	for _, c := range ts.conns {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -1,35 +1,52 @@
 package prefixer
 
 import (
-	"bufio"
 	"bytes"
 	"io"
 )
 
+// PrefixReader prefixes each line read from reader, partial lines are returned as soon as
+// they are read, eg: prompts, and the last line is ended by a newline.
 type PrefixReader struct {
-	reader *bufio.Reader
-	prefix string
+	reader  io.Reader
+	prefix  string
+	buf     []byte
+	pending []byte // prefixed data not returned yet
+	midLine bool   // data read so far ends in the middle of a line
 }
 
 func NewPrefixReader(reader io.Reader, prefix string) *PrefixReader {
 	if reader != nil {
-		return &PrefixReader{reader: bufio.NewReader(reader), prefix: prefix}
+		return &PrefixReader{reader: reader, prefix: prefix, buf: make([]byte, 4096)}
 	} else {
-		return &PrefixReader{reader: bufio.NewReader(bytes.NewReader(nil)), prefix: prefix}
+		return &PrefixReader{reader: bytes.NewReader(nil), prefix: prefix, buf: make([]byte, 4096)}
 	}
 
 }
 
 func (p *PrefixReader) Read(data []byte) (int, error) {
-	b, err := p.reader.ReadByte()
-	sb := []byte{b}
-	if err != nil {
-		n := copy(data, sb)
-		return n, err
+	if len(p.pending) == 0 {
+		n, err := p.reader.Read(p.buf)
+		for _, line := range bytes.SplitAfter(p.buf[:n], []byte("\n")) {
+			if len(line) == 0 {
+				continue
+			}
+			if !p.midLine {
+				p.pending = append(p.pending, p.prefix...)
+			}
+			p.pending = append(p.pending, line...)
+			p.midLine = line[len(line)-1] != '\n'
+		}
+		// last line is ended, so following output is not joined to it
+		if err == io.EOF && p.midLine {
+			p.pending = append(p.pending, '\n')
+			p.midLine = false
+		}
+		if len(p.pending) == 0 {
+			return 0, err
+		}
 	}
-	if b == '\n' {
-		sb = append(sb, []byte(p.prefix)...)
-	}
-	n := copy(data, sb)
+	n := copy(data, p.pending)
+	p.pending = p.pending[n:]
 	return n, nil
 }
@@ -0,0 +1,140 @@
+// Package shellquote quotes strings to be used as words of POSIX shell command lines.
+package shellquote
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Quote quotes s as a single shell word, s is returned as is if it contains safe characters only.
+func Quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if isSafe(s) {
+		return s
+	}
+	// single quotes preserve everything except single quote itself, which is closed,
+	// escaped and reopened
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Join quotes each of args and joins them with space.
+func Join(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = Quote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Path quotes path like Quote, but leaves a leading ~ unquoted to be expanded
+// to home directory by shell, and variable references, eg: $HOME or ${HOME}, to be
+// expanded by shell in double quotes.
+func Path(path string) string {
+	if path == "~" {
+		return path
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if rest == "" {
+			return "~/"
+		}
+		return "~/" + quoteVars(rest)
+	}
+	return quoteVars(path)
+}
+
+// quoteVars quotes s in double quotes if it refers to variables, otherwise like Quote.
+func quoteVars(s string) string {
+	if !strings.Contains(s, "$") {
+		return Quote(s)
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '$' {
+			if n := varRef(s[i+1:]); n > 0 {
+				b.WriteString(s[i : i+1+n])
+				i += n
+				continue
+			}
+		}
+		if strings.IndexByte("$`\\\"", s[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// varRef returns length of variable reference at start of s following $, eg: NAME or {NAME},
+// it returns 0 if s does not start with one.
+func varRef(s string) int {
+	if rest, ok := strings.CutPrefix(s, "{"); ok {
+		if end := strings.IndexByte(rest, '}'); end > 0 && IsName(rest[:end]) {
+			return end + 2
+		}
+		return 0
+	}
+	n := 0
+	for n < len(s) && IsName(s[:n+1]) {
+		n++
+	}
+	return n
+}
+
+// Assign returns a shell variable assignment of name to value.
+func Assign(name, value string) (string, error) {
+	if !IsName(name) {
+		return "", fmt.Errorf("invalid environment variable name: %q", name)
+	}
+	return name + "=" + Quote(value), nil
+}
+
+// Environ returns assignments of envs sorted by name, separated by space.
+func Environ(envs map[string]string) (string, error) {
+	names := make([]string, 0, len(envs))
+	for k := range envs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	assigns := make([]string, 0, len(names))
+	for _, k := range names {
+		a, err := Assign(k, envs[k])
+		if err != nil {
+			return "", err
+		}
+		assigns = append(assigns, a)
+	}
+	return strings.Join(assigns, " "), nil
+}
+
+// IsName reports whether name is a valid shell variable name.
+func IsName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isSafe(s string) bool {
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("@%+:,./_-", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package shellquote
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", "''"},
+		{"abc", "abc"},
+		{"/usr/local/bin", "/usr/local/bin"},
+		{"a b", "'a b'"},
+		{"it's", `'it'\''s'`},
+		{"$(rm -rf /)", "'$(rm -rf /)'"},
+		{"`id`", "'`id`'"},
+		{"a\nb", "'a\nb'"},
+		{"~user", "'~user'"},
+		{"$HOME", "'$HOME'"},
+	}
+	for _, tt := range tests {
+		if got := Quote(tt.in); got != tt.want {
+			t.Errorf("Quote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"echo", ""}, "echo ''"},
+		{[]string{"echo", "it's", "$(id)"}, `echo 'it'\''s' '$(id)'`},
+		{[]string{"sh", "-c", "a\nb"}, "sh -c 'a\nb'"},
+	}
+	for _, tt := range tests {
+		if got := Join(tt.in...); got != tt.want {
+			t.Errorf("Join(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", "''"},
+		{"~", "~"},
+		{"~/", "~/"},
+		{"~/app", "~/app"},
+		{"~/my app", "~/'my app'"},
+		{"~user/app", "'~user/app'"},
+		{"/tmp/it's", `'/tmp/it'\''s'`},
+		{"/tmp/$(id)", `"/tmp/\$(id)"`},
+		{"/tmp/`id`", "'/tmp/`id`'"},
+		{"/tmp/a\nb", "'/tmp/a\nb'"},
+		{"${HOME}/app", `"${HOME}/app"`},
+		{"$HOME/app", `"$HOME/app"`},
+		{"~/${APP}", `~/"${APP}"`},
+		{"${HOME}/`id`/\"x\"", "\"${HOME}/\\`id\\`/\\\"x\\\"\""},
+		{"${HOME}/$", `"${HOME}/\$"`},
+		{"${1}/a", `"\${1}/a"`},
+	}
+	for _, tt := range tests {
+		if got := Path(tt.in); got != tt.want {
+			t.Errorf("Path(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAssign(t *testing.T) {
+	tests := []struct {
+		name, value, want string
+		err               bool
+	}{
+		{"A", "", "A=''", false},
+		{"_a1", "x y", "_a1='x y'", false},
+		{"A", "it's", `A='it'\''s'`, false},
+		{"A", "$(id)", "A='$(id)'", false},
+		{"", "x", "", true},
+		{"1A", "x", "", true},
+		{"A-B", "x", "", true},
+		{"A;id", "x", "", true},
+		{"A B", "x", "", true},
+	}
+	for _, tt := range tests {
+		got, err := Assign(tt.name, tt.value)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("Assign(%q, %q) = %q, %v, want %q, error %v", tt.name, tt.value, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestEnviron(t *testing.T) {
+	tests := []struct {
+		envs map[string]string
+		want string
+		err  bool
+	}{
+		{nil, "", false},
+		{map[string]string{"B": "2", "A": "it's"}, `A='it'\''s' B=2`, false},
+		{map[string]string{"A": "`id`\n"}, "A='`id`\n'", false},
+		{map[string]string{"A": "1", "$(id)": "x"}, "", true},
+	}
+	for _, tt := range tests {
+		got, err := Environ(tt.envs)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("Environ(%v) = %q, %v, want %q, error %v", tt.envs, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+// TestRoundTrip checks quoted words are passed through shell unchanged.
+func TestRoundTrip(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	words := []string{"", "abc", "a b", "it's", "''", `"`, "$(id)", "`id`", "$HOME", "${HOME}", "a\nb", "~user", `\`, "*", "a;b|c&d", "\t"}
+	for _, w := range words {
+		out, err := exec.Command("sh", "-c", `printf %s "$1"`, "sh", w).Output()
+		if err != nil {
+			t.Fatalf("run sh failed: %v", err)
+		}
+		if string(out) != w {
+			t.Errorf("argument %q = %q", w, out)
+		}
+		out, err = exec.Command("sh", "-c", "printf %s "+Quote(w)).Output()
+		if err != nil {
+			t.Fatalf("run sh failed: %v", err)
+		}
+		if string(out) != w {
+			t.Errorf("Quote(%q) through sh = %q", w, out)
+		}
+	}
+	// variables of paths are expanded, everything else is kept
+	paths := map[string]string{
+		"${OPS_DIR}/a b":     "/srv/x/a b",
+		"$OPS_DIR/$(id)`id`": "/srv/x/$(id)`id`",
+		"/it's/${OPS_DIR}":   "/it's//srv/x",
+		"/a\"b\\c/$":         "/a\"b\\c/$",
+	}
+	for p, want := range paths {
+		cmd := exec.Command("sh", "-c", "printf %s "+Path(p))
+		cmd.Env = []string{"OPS_DIR=/srv/x"}
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("run sh failed: %v", err)
+		}
+		if string(out) != want {
+			t.Errorf("Path(%q) through sh = %q, want %q", p, out, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package transfer
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Cache builds an archive once into a local temporary file, and streams the file to each reader,
+// so archive sent to many hosts is not rebuilt for each of them.
+type Cache struct {
+	archive    func() (io.Reader, error)
+	once       sync.Once
+	path       string
+	size       int64
+	files      int64
+	bytes      int64
+	compressed bool
+	err        error
+}
+
+// NewCache returns cache of archive, which is built on first open.
+func NewCache(archive func() (io.Reader, error)) *Cache {
+	return &Cache{archive: archive}
+}
+
+// build writes archive into temporary file.
+func (c *Cache) build() error {
+	r, err := c.archive()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp("", "ops-archive-*")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	c.path = f.Name()
+	if c.size, err = io.Copy(f, r); err != nil {
+		return err
+	}
+	if s, ok := r.(*Stream); ok {
+		c.files, c.bytes, c.compressed = s.files.Load(), s.bytes.Load(), s.compressed
+	}
+	return nil
+}
+
+// Opener returns trigger function of archive read by n readers, archive is streamed directly
+// without a temporary file if it's read only once.
+func (c *Cache) Opener(n int) func() (io.Reader, error) {
+	if n <= 1 {
+		return c.archive
+	}
+	return c.Open
+}
+
+// Open streams cached archive, it could be used as trigger function of archive. The reader is
+// a *Stream reporting progress in archive bytes.
+func (c *Cache) Open() (io.Reader, error) {
+	c.once.Do(func() { c.err = c.build() })
+	if c.err != nil {
+		return nil, c.err
+	}
+	f, err := os.Open(c.path)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	stream := &Stream{reader: pr, compressed: c.compressed, cached: true, total: c.size}
+	stream.files.Store(c.files)
+	stream.bytes.Store(c.bytes)
+	go func() {
+		defer f.Close()
+		_, err := io.Copy(pw, f)
+		pw.CloseWithError(err)
+	}()
+	return stream, nil
+}
+
+// Close removes cached archive.
+func (c *Cache) Close() error {
+	if c.path == "" {
+		return nil
+	}
+	return os.Remove(c.path)
+}
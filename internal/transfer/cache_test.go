@@ -0,0 +1,49 @@
+package transfer
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCacheOpener(t *testing.T) {
+	builds := 0
+	cache := NewCache(func() (io.Reader, error) {
+		builds++
+		return strings.NewReader("archive"), nil
+	})
+	defer cache.Close()
+	// a single reader streams archive without a temporary file
+	r, err := cache.Opener(1)()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(r); string(data) != "archive" {
+		t.Fatalf("Opener(1) read %q, want archive", data)
+	}
+	if cache.path != "" {
+		t.Fatal("Opener(1) should not cache archive")
+	}
+	// many readers share archive built once
+	open := cache.Opener(3)
+	for i := 0; i < 3; i++ {
+		r, err := open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := io.ReadAll(r); string(data) != "archive" {
+			t.Fatalf("Opener(3) read %q, want archive", data)
+		}
+	}
+	if builds != 2 {
+		t.Errorf("archive built %d times, want 2", builds)
+	}
+	path := cache.path
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("cached archive should be removed, stat: %v", err)
+	}
+}
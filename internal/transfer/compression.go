@@ -0,0 +1,79 @@
+package transfer
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codecs of archive compression
+const (
+	CodecNone = "none"
+	CodecGzip = "gzip"
+	CodecZstd = "zstd"
+)
+
+// Compression is codec and level to compress archive with, zero level means default level of codec.
+type Compression struct {
+	Codec string
+	Level int
+}
+
+// ParseCompression parses compression of syntax: CODEC[:LEVEL], eg: gzip:9, empty s means gzip.
+func ParseCompression(s string) (Compression, error) {
+	if s == "" {
+		return Compression{Codec: CodecGzip}, nil
+	}
+	codec, level, hasLevel := strings.Cut(s, ":")
+	c := Compression{Codec: codec}
+	if hasLevel {
+		n, err := strconv.Atoi(level)
+		if err != nil {
+			return c, fmt.Errorf("invalid level of compression: %s", s)
+		}
+		c.Level = n
+	}
+	switch {
+	case codec == CodecNone && !hasLevel:
+	case codec == CodecGzip && (!hasLevel || c.Level >= gzip.BestSpeed && c.Level <= gzip.BestCompression):
+	case codec == CodecZstd && (!hasLevel || c.Level >= 1 && c.Level <= 22):
+	default:
+		return c, fmt.Errorf("invalid compression: %s, use none, gzip[:1-9] or zstd[:1-22] instead", s)
+	}
+	return c, nil
+}
+
+// Compressed reports whether c compresses archive.
+func (c Compression) Compressed() bool {
+	return c.Codec != CodecNone
+}
+
+// writer wraps w to compress data written to it.
+func (c Compression) writer(w io.Writer) (io.WriteCloser, error) {
+	switch c.Codec {
+	case CodecNone:
+		return nopWriteCloser{w}, nil
+	case CodecZstd:
+		if c.Level == 0 {
+			return zstd.NewWriter(w)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.Level)))
+	default:
+		if c.Level == 0 {
+			return gzip.NewWriter(w), nil
+		}
+		return gzip.NewWriterLevel(w, c.Level)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
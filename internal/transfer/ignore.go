@@ -0,0 +1,162 @@
+package transfer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile is the name of file in source directory listing patterns of files not to transfer.
+const IgnoreFile = ".opsignore"
+
+// Ignore matches slash separated names relative to source against gitignore style patterns,
+// later patterns take precedence over earlier ones.
+type Ignore struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// NewIgnore parses patterns of gitignore syntax, blank lines and comments are skipped.
+func NewIgnore(patterns ...string) (*Ignore, error) {
+	ig := &Ignore{}
+	for _, p := range patterns {
+		if err := ig.add(p); err != nil {
+			return nil, err
+		}
+	}
+	return ig, nil
+}
+
+// LoadIgnore reads patterns of ignore file in src directory if exists, followed by patterns.
+// The ignore file itself is never transferred.
+func LoadIgnore(src string, patterns []string) (*Ignore, error) {
+	lines := []string{"/" + IgnoreFile}
+	if fi, err := os.Stat(src); err == nil && fi.IsDir() {
+		data, err := os.ReadFile(filepath.Join(src, IgnoreFile))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		lines = append(lines, strings.Split(string(data), "\n")...)
+	}
+	return NewIgnore(append(lines, patterns...)...)
+}
+
+func (ig *Ignore) add(pattern string) error {
+	p := strings.TrimRight(pattern, " \t\r")
+	if p == "" || strings.HasPrefix(p, "#") {
+		return nil
+	}
+	rule := ignoreRule{}
+	if strings.HasPrefix(p, "!") {
+		rule.negate = true
+		p = p[1:]
+	} else if strings.HasPrefix(p, `\!`) || strings.HasPrefix(p, `\#`) {
+		p = p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		rule.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	if p == "" {
+		return nil
+	}
+	// patterns with slash in the middle or beginning are relative to source,
+	// others match at any level
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+	expr := globToRegexp(p)
+	if anchored {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "^(?:.*/)?" + expr + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	rule.re = re
+	ig.rules = append(ig.rules, rule)
+	return nil
+}
+
+// Match reports whether name itself matches patterns.
+func (ig *Ignore) Match(name string, isDir bool) bool {
+	if ig == nil {
+		return false
+	}
+	matched := false
+	for _, r := range ig.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if r.re.MatchString(name) {
+			matched = !r.negate
+		}
+	}
+	return matched
+}
+
+// Excluded reports whether name or any of its parent directories matches patterns.
+func (ig *Ignore) Excluded(name string, isDir bool) bool {
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		if ig.Match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return ig.Match(name, isDir)
+}
+
+// globToRegexp converts glob of gitignore syntax to regular expression.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				// ** matches across directories
+				atStart := i == 0 || glob[i-1] == '/'
+				i++
+				if atStart && i+1 < len(glob) && glob[i+1] == '/' {
+					// **/ matches zero or more directories
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,101 @@
+package transfer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoreExcluded(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		name     string
+		isDir    bool
+		want     bool
+	}{
+		// unanchored patterns match at any level
+		{[]string{"*.log"}, "a.log", false, true},
+		{[]string{"*.log"}, "x/y/a.log", false, true},
+		{[]string{"*.log"}, "a.txt", false, false},
+		// **/ matches zero or more directories
+		{[]string{"**/cache"}, "cache", true, true},
+		{[]string{"**/cache"}, "x/y/cache", true, true},
+		{[]string{"a/**/b"}, "a/b", false, true},
+		{[]string{"a/**/b"}, "a/x/y/b", false, true},
+		{[]string{"a/**/b"}, "c/a/b", false, false},
+		{[]string{"a/**"}, "a/x/y", false, true},
+		// * does not match across directories
+		{[]string{"a/*.go"}, "a/x/b.go", false, false},
+		// leading slash anchors to source
+		{[]string{"/build"}, "build", true, true},
+		{[]string{"/build"}, "x/build", true, false},
+		{[]string{"x/build"}, "x/build", true, true},
+		{[]string{"x/build"}, "y/x/build", true, false},
+		// trailing slash matches directories only
+		{[]string{"logs/"}, "logs", true, true},
+		{[]string{"logs/"}, "logs", false, false},
+		{[]string{"logs/"}, "x/logs/a", false, true},
+		// later negation re-includes
+		{[]string{"*.log", "!keep.log"}, "keep.log", false, false},
+		{[]string{"*.log", "!keep.log"}, "x.log", false, true},
+		{[]string{"!keep.log", "*.log"}, "keep.log", false, true},
+		// files are not re-included under an excluded parent
+		{[]string{"logs/", "!logs/keep.log"}, "logs/keep.log", false, true},
+		{[]string{"logs/*", "!logs/keep.log"}, "logs/keep.log", false, false},
+		// escaped characters are literal
+		{[]string{`\#notes`}, "#notes", false, true},
+		{[]string{`\!important`}, "!important", false, true},
+		{[]string{`a\*b`}, "a*b", false, true},
+		{[]string{`a\*b`}, "axb", false, false},
+		{[]string{"a?c"}, "abc", false, true},
+		{[]string{"[ab].txt"}, "b.txt", false, true},
+		{[]string{"[!ab].txt"}, "b.txt", false, false},
+		// comments and blank lines are skipped
+		{[]string{"# *.log", "", "   "}, "a.log", false, false},
+	}
+	for _, tt := range tests {
+		ig, err := NewIgnore(tt.patterns...)
+		if err != nil {
+			t.Fatalf("NewIgnore(%q) failed: %v", tt.patterns, err)
+		}
+		if got := ig.Excluded(tt.name, tt.isDir); got != tt.want {
+			t.Errorf("NewIgnore(%q).Excluded(%q, %v) = %v, want %v", tt.patterns, tt.name, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestLoadIgnore(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, IgnoreFile), []byte("*.log\n# comment\ntmp/\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ig, err := LoadIgnore(src, []string{"!keep.log", "*.bak"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		isDir bool
+		want  bool
+	}{
+		{IgnoreFile, false, true},
+		{"x/" + IgnoreFile, false, false},
+		{"a.log", false, true},
+		{"keep.log", false, false},
+		{"tmp", true, true},
+		{"a.bak", false, true},
+		{"a.txt", false, false},
+	}
+	for _, tt := range tests {
+		if got := ig.Excluded(tt.name, tt.isDir); got != tt.want {
+			t.Errorf("Excluded(%q, %v) = %v, want %v", tt.name, tt.isDir, got, tt.want)
+		}
+	}
+	// a missing ignore file and a single file source only use patterns
+	if ig, err = LoadIgnore(filepath.Join(src, "missing"), []string{"*.bak"}); err != nil {
+		t.Fatal(err)
+	}
+	if !ig.Excluded("a.bak", false) || ig.Excluded("a.log", false) {
+		t.Error("LoadIgnore() of missing source should only use patterns")
+	}
+}
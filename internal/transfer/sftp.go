@@ -0,0 +1,218 @@
+package transfer
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// ExtractSFTP replicates entries of tar archive read from r into dest directory through SFTP,
+// directories, symlinks and modes of entries are kept, and ownership is applied if owner is
+// not nil, whose user and group must be numeric ids.
+func ExtractSFTP(client *sftp.Client, r io.Reader, dest string, owner *Owner) error {
+	if fi, err := client.Stat(dest); err != nil || !fi.IsDir() {
+		return fmt.Errorf("directory: [%s] does not exist", dest)
+	}
+	uid, gid, err := numericOwner(owner)
+	if err != nil {
+		return err
+	}
+	// modes of directories are applied after their entries are written, as they may be read only
+	type dirMode struct {
+		name string
+		mode os.FileMode
+	}
+	dirs := make([]dirMode, 0)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !fs.ValidPath(strings.TrimSuffix(header.Name, "/")) {
+			return fmt.Errorf("invalid name of archive entry: %s", header.Name)
+		}
+		name := path.Join(dest, header.Name)
+		mode := header.FileInfo().Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := client.MkdirAll(name); err != nil {
+				return fmt.Errorf("create directory: %s failed: %w", name, err)
+			}
+			dirs = append(dirs, dirMode{name: name, mode: mode})
+		case tar.TypeSymlink:
+			// replace existing file like tar does
+			if err := client.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("replace %s failed: %w", name, err)
+			}
+			if err := client.Symlink(header.Linkname, name); err != nil {
+				return fmt.Errorf("create symlink: %s failed: %w", name, err)
+			}
+			continue
+		case tar.TypeReg:
+			if err := putFile(client, tr, name); err != nil {
+				return err
+			}
+			if err := client.Chmod(name, mode); err != nil {
+				return fmt.Errorf("change mode of %s failed: %w", name, err)
+			}
+		default:
+			continue
+		}
+		if owner != nil {
+			if err := client.Chown(name, uid, gid); err != nil {
+				return fmt.Errorf("change owner of %s failed: %w", name, err)
+			}
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := client.Chmod(dirs[i].name, dirs[i].mode); err != nil {
+			return fmt.Errorf("change mode of %s failed: %w", dirs[i].name, err)
+		}
+	}
+	return nil
+}
+
+// putFile writes content of r to remote file of name, truncating it if exists.
+func putFile(client *sftp.Client, r io.Reader, name string) error {
+	f, err := client.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("create file: %s failed: %w", name, err)
+	}
+	if _, err := f.ReadFrom(r); err != nil {
+		f.Close()
+		return fmt.Errorf("write file: %s failed: %w", name, err)
+	}
+	return f.Close()
+}
+
+// PutOptions are options to put a single file through SFTP.
+type PutOptions struct {
+	Size   int64       // size of content
+	Part   string      // name of partial file beside dest, which is resumed if it exists
+	Mode   os.FileMode // mode of dest
+	Owner  *Owner      // numeric ownership of dest if not nil
+	Backup bool        // keep replaced dest as dest.bak-TIMESTAMP
+}
+
+// PutSFTP uploads content read from r to exactly dest through SFTP. Content is written to a
+// partial file beside dest first, which is resumed if it exists, and then renamed to dest.
+func PutSFTP(client *sftp.Client, r io.Reader, dest string, opts PutOptions) error {
+	uid, gid, err := numericOwner(opts.Owner)
+	if err != nil {
+		return err
+	}
+	part := path.Join(path.Dir(dest), opts.Part)
+	f, err := client.OpenFile(part, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return fmt.Errorf("create file: %s failed: %w", part, err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset := fi.Size()
+	if offset > opts.Size {
+		// not a partial of content, start over
+		offset = 0
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+	}
+	// skip content sent before
+	if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+		return err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := f.ReadFrom(r); err != nil {
+		return fmt.Errorf("write file: %s failed: %w", part, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := client.Chmod(part, opts.Mode); err != nil {
+		return fmt.Errorf("change mode of %s failed: %w", part, err)
+	}
+	if opts.Owner != nil {
+		if err := client.Chown(part, uid, gid); err != nil {
+			return fmt.Errorf("change owner of %s failed: %w", part, err)
+		}
+	}
+	_, posix := client.HasExtension("posix-rename@openssh.com")
+	if opts.Backup {
+		if _, err := client.Lstat(dest); err == nil {
+			backup := dest + ".bak-" + time.Now().Format("20060102150405")
+			// hard link keeps dest in place until it's replaced
+			if _, ok := client.HasExtension("hardlink@openssh.com"); ok && posix {
+				err = client.Link(dest, backup)
+			} else {
+				err = client.Rename(dest, backup)
+			}
+			if err != nil {
+				return fmt.Errorf("backup %s failed: %w", dest, err)
+			}
+		}
+	}
+	if posix {
+		err = client.PosixRename(part, dest)
+	} else {
+		// plain rename of sftp fails if dest exists
+		if err := client.Remove(dest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("replace %s failed: %w", dest, err)
+		}
+		err = client.Rename(part, dest)
+	}
+	if err != nil {
+		return fmt.Errorf("rename %s to %s failed: %w", part, dest, err)
+	}
+	return nil
+}
+
+// SumSFTP returns hex encoded sha256 of remote file read through SFTP.
+func SumSFTP(client *sftp.Client, name string) (string, error) {
+	f, err := client.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := f.WriteTo(h); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// numericOwner returns numeric ids of owner, -1 for empty ones.
+func numericOwner(owner *Owner) (int, int, error) {
+	if owner == nil {
+		return -1, -1, nil
+	}
+	uid, uname := ownerID(owner.User)
+	gid, gname := ownerID(owner.Group)
+	if uname != "" || gname != "" {
+		return 0, 0, errors.New("owner and group must be numeric ids through sftp")
+	}
+	if owner.User == "" {
+		uid = -1
+	}
+	if owner.Group == "" {
+		gid = -1
+	}
+	return uid, gid, nil
+}
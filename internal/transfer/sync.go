@@ -0,0 +1,170 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jevi061/ops/internal/shellquote"
+)
+
+// SyncPlan is changes to make remote files same as local files.
+type SyncPlan struct {
+	Added       []File   // files missing on remote
+	Changed     []File   // files differ from remote
+	Deleted     []string // names of remote files missing in local
+	DeletedDirs []string // names of remote directories missing in local, deepest first
+	Unchanged   int
+	Entries     []File // directories and symlinks, always sent to keep them up to date
+}
+
+// dirPrefix prefixes names of directories in output of ListCommand, which are terminated by NUL.
+const dirPrefix = "dir "
+
+// ListCommand returns command to list sha256 sums of remote files under root of dest, followed
+// by names of directories, root is the top level name of archive.
+func ListCommand(dest, root string) string {
+	return fmt.Sprintf(`cd -- %s 2>/dev/null || exit 0; [ -e %s ] || exit 0; find ./%s -type f -exec sha256sum {} + && find ./%s -type d -exec printf '%s%%s\000' {} +`,
+		shellquote.Path(dest), shellquote.Quote(root), shellquote.Quote(root), shellquote.Quote(root), dirPrefix)
+}
+
+// ParseSums parses output of sha256sum to sums keyed by names, directories listed by ListCommand
+// have empty sums.
+func ParseSums(out []byte) (map[string]string, error) {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Split(scanListing)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if name, ok := strings.CutPrefix(line, dirPrefix); ok {
+			sums[strings.TrimPrefix(name, "./")] = ""
+			continue
+		}
+		// names with backslash or newline are escaped and the line is prefixed with backslash
+		escaped := strings.HasPrefix(line, `\`)
+		line = strings.TrimPrefix(line, `\`)
+		sum, name, ok := strings.Cut(line, " ")
+		if !ok || len(sum) != 64 {
+			return nil, fmt.Errorf("unexpected checksum line: %s", line)
+		}
+		name = strings.TrimPrefix(strings.TrimPrefix(name, " "), "*")
+		if escaped {
+			name = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(name)
+		}
+		sums[strings.TrimPrefix(name, "./")] = sum
+	}
+	return sums, scanner.Err()
+}
+
+// scanListing splits lines of sha256sum by newline, and names of directories by NUL as they
+// are not escaped.
+func scanListing(data []byte, atEOF bool) (int, []byte, error) {
+	sep := byte('\n')
+	if bytes.HasPrefix(data, []byte(dirPrefix)) {
+		sep = 0
+	}
+	if i := bytes.IndexByte(data, sep); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// Plan compares checksummed local files with remote sums, remote files and directories missing
+// in local are deleted if delete is true, unless they are excluded by ignore.
+func Plan(files []File, remote map[string]string, delete bool, ignore *Ignore) *SyncPlan {
+	plan := &SyncPlan{}
+	local := make(map[string]bool, len(files))
+	for _, f := range files {
+		local[f.Name] = true
+		if !f.Regular() {
+			plan.Entries = append(plan.Entries, f)
+			continue
+		}
+		sum, ok := remote[f.Name]
+		switch {
+		case !ok:
+			plan.Added = append(plan.Added, f)
+		case sum != f.Sum:
+			plan.Changed = append(plan.Changed, f)
+		default:
+			plan.Unchanged++
+		}
+	}
+	if delete {
+		for name := range remote {
+			if local[name] {
+				continue
+			}
+			// patterns are relative to root
+			_, rel, _ := strings.Cut(name, "/")
+			dir := remote[name] == ""
+			if rel != "" && ignore.Excluded(rel, dir) {
+				continue
+			}
+			if dir {
+				plan.DeletedDirs = append(plan.DeletedDirs, name)
+			} else {
+				plan.Deleted = append(plan.Deleted, name)
+			}
+		}
+		sort.Strings(plan.Deleted)
+		// children are sorted after their parents
+		sort.Sort(sort.Reverse(sort.StringSlice(plan.DeletedDirs)))
+	}
+	return plan
+}
+
+// Verify compares checksummed local regular files with remote sums, and reports files
+// missing on remote or differ from remote.
+func Verify(files []File, remote map[string]string) error {
+	mismatched := make([]string, 0)
+	for _, f := range files {
+		if f.Regular() && remote[f.Name] != f.Sum {
+			mismatched = append(mismatched, f.Name)
+		}
+	}
+	if len(mismatched) == 0 {
+		return nil
+	}
+	if len(mismatched) > 3 {
+		mismatched = append(mismatched[:3], fmt.Sprintf("and %d more", len(mismatched)-3))
+	}
+	return fmt.Errorf("verify failed, checksums of remote files differ from local: %s", strings.Join(mismatched, ", "))
+}
+
+// Files returns files to send, including all directories and symlinks.
+func (p *SyncPlan) Files() []File {
+	return append(append(append([]File{}, p.Entries...), p.Added...), p.Changed...)
+}
+
+// Empty reports whether nothing to change.
+func (p *SyncPlan) Empty() bool {
+	return len(p.Added) == 0 && len(p.Changed) == 0 && len(p.Deleted) == 0 && len(p.DeletedDirs) == 0
+}
+
+// String lists changes of plan, one line per file.
+func (p *SyncPlan) String() string {
+	var b strings.Builder
+	for _, f := range p.Added {
+		fmt.Fprintf(&b, "+ %s\n", f.Name)
+	}
+	for _, f := range p.Changed {
+		fmt.Fprintf(&b, "~ %s\n", f.Name)
+	}
+	for _, name := range p.Deleted {
+		fmt.Fprintf(&b, "- %s\n", name)
+	}
+	for _, name := range p.DeletedDirs {
+		fmt.Fprintf(&b, "- %s/\n", name)
+	}
+	fmt.Fprintf(&b, "sync: %d added, %d changed, %d deleted, %d unchanged\n", len(p.Added), len(p.Changed), len(p.Deleted)+len(p.DeletedDirs), p.Unchanged)
+	return b.String()
+}
@@ -0,0 +1,53 @@
+package transfer
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSums(t *testing.T) {
+	sum := strings.Repeat("a", 64)
+	out := sum + "  ./src/a\n" +
+		`\` + sum + `  ./src/b\nc` + "\n" +
+		"dir ./src\x00dir ./src/x\ny\x00"
+	got, err := ParseSums([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"src/a": sum, "src/b\nc": sum, "src": "", "src/x\ny": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseSums() = %q, want %q", got, want)
+	}
+	if _, err := ParseSums([]byte("bad line\n")); err == nil {
+		t.Error("ParseSums() of bad line should fail")
+	}
+}
+
+func TestPlanDeletesDirs(t *testing.T) {
+	sum := strings.Repeat("a", 64)
+	ignore, err := NewIgnore("keep/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := []File{{Name: "src", Info: dirInfo(t)}}
+	remote := map[string]string{
+		"src": "", "src/a": sum, "src/x": "", "src/x/y": "", "src/x/y/b": sum, "src/keep": "", "src/keep/c": sum,
+	}
+	plan := Plan(local, remote, true, ignore)
+	if want := []string{"src/a", "src/x/y/b"}; !reflect.DeepEqual(plan.Deleted, want) {
+		t.Errorf("Deleted = %q, want %q", plan.Deleted, want)
+	}
+	if want := []string{"src/x/y", "src/x"}; !reflect.DeepEqual(plan.DeletedDirs, want) {
+		t.Errorf("DeletedDirs = %q, want %q", plan.DeletedDirs, want)
+	}
+}
+
+func dirInfo(t *testing.T) os.FileInfo {
+	fi, err := os.Stat(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi
+}
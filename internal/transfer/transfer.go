@@ -2,13 +2,16 @@ package transfer
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 // Validate validates transfer syntex.
@@ -32,7 +35,7 @@ func ParseTransfer(trans string) (string, string, error) {
 }
 
 // ParsePayloadWithEnvs parses transfer directive to get source and dest from it,and source will be
-// expanded using provided envs.
+// expanded using provided envs. Variables of dest not in envs are kept to be expanded by remote shell.
 func ParsePayloadWithEnvs(trans string, envs map[string]string) (string, string, error) {
 	if err := Validate(trans); err != nil {
 		return "", "", err
@@ -42,78 +45,327 @@ func ParsePayloadWithEnvs(trans string, envs map[string]string) (string, string,
 	if err != nil {
 		return "", "", fmt.Errorf("resolve upload src file path failed:%w", err)
 	}
-	return absSrc, fields[2], nil
+	dest := os.Expand(fields[2], func(s string) string {
+		if v, ok := envs[s]; ok {
+			return v
+		}
+		return "${" + s + "}"
+	})
+	return absSrc, dest, nil
+}
+
+// File is a local file, directory or symlink to transfer.
+type File struct {
+	Path string      // local path
+	Name string      // slash separated name in archive, relative to parent of source
+	Info os.FileInfo // file info of local path, of target if symlink is followed
+	Link string      // target of symlink
+	Sum  string      // hex encoded sha256 of content of regular file, filled by Checksum
+}
+
+// Regular reports whether f is a regular file with content.
+func (f File) Regular() bool {
+	return f.Info.Mode().IsRegular()
+}
+
+// Walk lists regular files, directories and symlinks of src, files and directories excluded by ignore
+// are skipped. Symlinks are replaced by their targets if follow is true, src itself is always followed.
+func Walk(src string, ignore *Ignore, follow bool) ([]File, error) {
+	// ensure the src actually exists before trying to walk it
+	fi, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	w := &walker{src: src, pre: filepath.Dir(src), ignore: ignore, follow: follow, visited: make(map[string]bool)}
+	if err := w.walk(src, fi); err != nil {
+		return nil, err
+	}
+	return w.files, nil
+}
+
+type walker struct {
+	src     string
+	pre     string
+	ignore  *Ignore
+	follow  bool
+	visited map[string]bool // real paths of followed directories, to break loops
+	files   []File
+}
+
+func (w *walker) walk(file string, fi os.FileInfo) error {
+	rel, err := filepath.Rel(w.src, file)
+	if err != nil {
+		return err
+	}
+	// update the name to correctly reflect the desired destination when untaring
+	name, err := filepath.Rel(w.pre, file)
+	if err != nil {
+		return err
+	}
+	f := File{Path: file, Name: filepath.ToSlash(name), Info: fi}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		if target, err := os.Stat(file); w.follow && err == nil {
+			f.Info = target
+		} else if f.Link, err = os.Readlink(file); err != nil {
+			return err
+		}
+	}
+	if rel != "." && w.ignore.Match(filepath.ToSlash(rel), f.Info.IsDir()) {
+		return nil
+	}
+	switch {
+	case f.Link != "":
+		// dangling links are kept as is even if followed
+		w.files = append(w.files, f)
+	case f.Info.Mode().IsRegular():
+		w.files = append(w.files, f)
+	case f.Info.IsDir():
+		real, err := filepath.EvalSymlinks(file)
+		if err != nil {
+			return err
+		}
+		if w.visited[real] {
+			return fmt.Errorf("symlink loop found at: %s", file)
+		}
+		w.visited[real] = true
+		defer delete(w.visited, real)
+		w.files = append(w.files, f)
+		entries, err := os.ReadDir(file)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if err := w.walk(filepath.Join(file, entry.Name()), info); err != nil {
+				return err
+			}
+		}
+	}
+	// sockets, devices and pipes are skipped
+	return nil
+}
+
+// Checksum fills sha256 sums of files.
+func Checksum(files []File) error {
+	for i := range files {
+		if !files[i].Regular() {
+			continue
+		}
+		f, err := os.Open(files[i].Path)
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		files[i].Sum = hex.EncodeToString(h.Sum(nil))
+	}
+	return nil
 }
 
 // PipeFile pipes source of file or directory to a trigger function,
 // which could used to send source data to io.Write.
 func PipeFile(src string) func() (io.Reader, error) {
-	piper := func() (io.Reader, error) {
-		//fmt.Println("pipe file:", src)
-		pr, pw := io.Pipe()
+	return func() (io.Reader, error) {
+		files, err := Walk(src, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		return Archive(files, nil, Compression{Codec: CodecGzip})()
+	}
+}
 
-		// ensure the src actually exists before trying to tar it
-		if _, err := os.Stat(src); err != nil {
-			fmt.Fprintln(os.Stderr, err)
+// Copy pipes content of a single file to a trigger function, the reader is a *Stream.
+func Copy(src string) func() (io.Reader, error) {
+	return func() (io.Reader, error) {
+		f, err := os.Open(src)
+		if err != nil {
 			return nil, err
 		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		pr, pw := io.Pipe()
+		stream := &Stream{reader: pr, total: fi.Size()}
+		go func() {
+			defer f.Close()
+			_, err := io.Copy(pw, &countingReader{reader: f, n: &stream.bytes})
+			if err == nil {
+				stream.files.Add(1)
+			}
+			// readers get error of copying instead of a truncated file
+			pw.CloseWithError(err)
+		}()
+		return stream, nil
+	}
+}
+
+// Owner maps ownership of archived files, a user or group could be a name or numeric id.
+// Empty fields are archived as UnsetID, to be replaced by default ownership of remote user
+// after extracting.
+type Owner struct {
+	User  string
+	Group string
+}
 
-		gzipw := gzip.NewWriter(pw)
+// UnsetID is uid or gid of archived files for empty fields of Owner, it's unlikely to be
+// used by any remote file, and fits in ustar headers.
+const UnsetID = 2097150
 
-		tw := tar.NewWriter(gzipw)
+// Archive pipes files as a compressed tar to a trigger function, the reader is a *Stream.
+func Archive(files []File, owner *Owner, comp Compression) func() (io.Reader, error) {
+	piper := func() (io.Reader, error) {
+		pr, pw := io.Pipe()
+		cw, err := comp.writer(pw)
+		if err != nil {
+			pr.Close()
+			pw.Close()
+			return nil, err
+		}
+		tw := tar.NewWriter(cw)
+		stream := &Stream{reader: pr, compressed: comp.Compressed()}
+		for _, file := range files {
+			if file.Regular() {
+				stream.total += file.Info.Size()
+			}
+		}
 
 		go func() {
-			defer pw.Close()
-			defer gzipw.Close()
-			defer tw.Close()
-			// walk path
-			err := filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
-				// return on any error
-				if err != nil {
+			err := func() error {
+				for _, file := range files {
+					if err := writeFile(tw, file, owner, &stream.bytes); err != nil {
+						return err
+					}
+					if file.Regular() {
+						stream.files.Add(1)
+					}
+				}
+				if err := tw.Close(); err != nil {
 					return err
 				}
+				return cw.Close()
+			}()
+			// readers get error of archiving instead of a truncated archive
+			pw.CloseWithError(err)
+		}()
 
-				if !fi.Mode().IsRegular() {
-					return nil
-				}
+		return stream, nil
+	}
+	return piper
+}
 
-				// create a new dir/file header
-				header, err := tar.FileInfoHeader(fi, fi.Name())
-				if err != nil {
-					return err
-				}
-				pre := filepath.Dir(src)
-				// update the name to correctly reflect the desired destination when untaring
-				header.Name = strings.TrimPrefix(strings.Replace(file, pre, "", -1), string(os.PathSeparator))
-				header.Name = strings.Replace(header.Name, string(os.PathSeparator), "/", -1)
+// Stream is reader of archive or file, which counts files and bytes sent through it.
+type Stream struct {
+	reader     io.Reader
+	compressed bool
+	cached     bool         // archive is read from cache, whose progress is counted in archive bytes
+	total      int64        // content bytes of all files, or bytes of cached archive
+	files      atomic.Int64 // files archived
+	bytes      atomic.Int64 // content bytes of files archived
+	sent       atomic.Int64 // bytes of archive read
+}
 
-				// write the header
-				if err := tw.WriteHeader(header); err != nil {
-					return err
-				}
+func (s *Stream) Read(p []byte) (int, error) {
+	n, err := s.reader.Read(p)
+	s.sent.Add(int64(n))
+	return n, err
+}
 
-				// open files for taring
-				f, err := os.Open(file)
-				if err != nil {
-					return err
-				}
+// Summary describes files and bytes sent.
+func (s *Stream) Summary() string {
+	if !s.compressed {
+		return fmt.Sprintf("%d files, %s", s.files.Load(), FormatBytes(s.bytes.Load()))
+	}
+	return fmt.Sprintf("%d files, %s (%s compressed)", s.files.Load(), FormatBytes(s.bytes.Load()), FormatBytes(s.sent.Load()))
+}
 
-				// copy file data into tar writer
-				if _, err := io.Copy(tw, f); err != nil {
-					return err
-				}
+// Progress returns bytes sent and total bytes to send.
+func (s *Stream) Progress() (int64, int64) {
+	if s.cached {
+		return s.sent.Load(), s.total
+	}
+	return s.bytes.Load(), s.total
+}
 
-				// manually close here after each file operation; defering would cause each file close
-				// to wait until all operations have completed.
-				return f.Close()
-			})
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-			}
-		}()
+// countingReader counts bytes read through it.
+type countingReader struct {
+	reader io.Reader
+	n      *atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
 
-		return pr, nil
+// FormatBytes formats n bytes in human readable units.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func writeFile(tw *tar.Writer, file File, owner *Owner, written *atomic.Int64) error {
+	// create a new dir/file/symlink header
+	header, err := tar.FileInfoHeader(file.Info, file.Link)
+	if err != nil {
+		return err
+	}
+	header.Name = file.Name
+	if file.Info.IsDir() {
+		header.Name += "/"
+	}
+	header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+	if owner != nil {
+		header.Uid, header.Gid = UnsetID, UnsetID
+		if owner.User != "" {
+			header.Uid, header.Uname = ownerID(owner.User)
+		}
+		if owner.Group != "" {
+			header.Gid, header.Gname = ownerID(owner.Group)
+		}
+	}
+
+	// write the header
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if !file.Regular() {
+		return nil
 	}
-	return piper
 
+	// open files for taring
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// copy file data into tar writer
+	_, err = io.Copy(tw, &countingReader{reader: f, n: written})
+	return err
+}
+
+// ownerID splits a user or group to numeric id and name.
+func ownerID(s string) (int, string) {
+	if id, err := strconv.Atoi(s); err == nil {
+		return id, ""
+	}
+	return 0, s
 }
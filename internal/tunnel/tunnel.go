@@ -0,0 +1,260 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Kinds of tunnels, named after flags of ssh
+const (
+	Local   = "L" // forward local port to address reachable from server
+	Remote  = "R" // forward port of server to address reachable from local
+	Dynamic = "D" // SOCKS5 proxy on local port, connecting through server
+)
+
+// Spec describes a tunnel, eg: -L 5432:localhost:5432.
+type Spec struct {
+	Kind   string
+	Listen string // address to listen on, it's on server for remote tunnels
+	Target string // address to connect to, empty for dynamic tunnels
+	raw    string
+}
+
+// Parse parses spec of tunnel of kind. Local and remote specs are in syntax of
+// [BIND_ADDRESS:]PORT:HOST:HOSTPORT, dynamic specs are in syntax of [BIND_ADDRESS:]PORT.
+// Tunnels listen on loopback address unless BIND_ADDRESS is set, * means all interfaces.
+func Parse(kind, s string) (Spec, error) {
+	spec := Spec{Kind: kind, raw: s}
+	fields, err := splitAddr(s)
+	if err != nil {
+		return spec, fmt.Errorf("invalid tunnel: -%s %s: %w", kind, s, err)
+	}
+	var bind, port []string
+	switch kind {
+	case Local, Remote:
+		if len(fields) != 3 && len(fields) != 4 {
+			return spec, fmt.Errorf("invalid tunnel: -%s %s, use [BIND_ADDRESS:]PORT:HOST:HOSTPORT instead", kind, s)
+		}
+		bind, port = fields[:len(fields)-3], fields[len(fields)-3:len(fields)-2]
+		host, hostPort := fields[len(fields)-2], fields[len(fields)-1]
+		if host == "" {
+			return spec, fmt.Errorf("invalid tunnel: -%s %s, host is required", kind, s)
+		}
+		if n, err := strconv.ParseUint(hostPort, 10, 16); err != nil || n == 0 {
+			return spec, fmt.Errorf("invalid tunnel: -%s %s, %s is not a valid port", kind, s, hostPort)
+		}
+		spec.Target = net.JoinHostPort(host, hostPort)
+	case Dynamic:
+		if len(fields) != 1 && len(fields) != 2 {
+			return spec, fmt.Errorf("invalid tunnel: -%s %s, use [BIND_ADDRESS:]PORT instead", kind, s)
+		}
+		bind, port = fields[:len(fields)-1], fields[len(fields)-1:]
+	default:
+		return spec, fmt.Errorf("unknown kind of tunnel: %s", kind)
+	}
+	if _, err := strconv.ParseUint(port[0], 10, 16); err != nil {
+		return spec, fmt.Errorf("invalid tunnel: -%s %s, %s is not a valid port", kind, s, port[0])
+	}
+	host := "localhost"
+	if len(bind) > 0 {
+		switch bind[0] {
+		case "", "*":
+			host = "0.0.0.0"
+		default:
+			host = bind[0]
+		}
+	}
+	spec.Listen = net.JoinHostPort(host, port[0])
+	return spec, nil
+}
+
+// splitAddr splits s by colons, colons of IPv6 addresses in brackets are kept.
+func splitAddr(s string) ([]string, error) {
+	fields := make([]string, 0)
+	for len(s) > 0 {
+		if !strings.HasPrefix(s, "[") {
+			var field string
+			field, s, _ = strings.Cut(s, ":")
+			fields = append(fields, field)
+			continue
+		}
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return nil, errors.New("missing ]")
+		}
+		fields = append(fields, s[1:end])
+		s = s[end+1:]
+		if s != "" && !strings.HasPrefix(s, ":") {
+			return nil, errors.New("missing : after ]")
+		}
+		s = strings.TrimPrefix(s, ":")
+	}
+	return fields, nil
+}
+
+func (s Spec) String() string {
+	return fmt.Sprintf("-%s %s", s.Kind, s.raw)
+}
+
+// Client opens connections and listeners through server, eg: *connector.SSHConnector, hosts of
+// listen addresses are resolved by server.
+type Client interface {
+	Dial(network, addr string) (net.Conn, error)
+	Listen(network, addr string) (net.Listener, error)
+}
+
+// Tunnel forwards connections accepted on its listener until closed.
+type Tunnel struct {
+	Spec     Spec
+	client   Client
+	listener net.Listener
+	onError  func(error)
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{} // forwarding connections
+	wg       sync.WaitGroup
+}
+
+// WithErrorHandler handles errors of forwarding connections, which are dropped by default.
+func WithErrorHandler(handler func(error)) func(*Tunnel) {
+	return func(t *Tunnel) {
+		t.onError = handler
+	}
+}
+
+// Open opens tunnel of spec through client.
+func Open(client Client, spec Spec, options ...func(*Tunnel)) (*Tunnel, error) {
+	t := &Tunnel{Spec: spec, client: client, onError: func(error) {}, conns: make(map[net.Conn]struct{})}
+	for _, option := range options {
+		option(t)
+	}
+	var err error
+	if spec.Kind == Remote {
+		t.listener, err = client.Listen("tcp", spec.Listen)
+	} else {
+		t.listener, err = net.Listen("tcp", spec.Listen)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s failed: %w", spec.Listen, err)
+	}
+	t.wg.Add(1)
+	go t.serve()
+	return t, nil
+}
+
+// Addr returns address tunnel listens on.
+func (t *Tunnel) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+// Close stops listening and closes forwarding connections.
+func (t *Tunnel) Close() error {
+	err := t.listener.Close()
+	t.mu.Lock()
+	for c := range t.conns {
+		c.Close()
+	}
+	t.conns = nil
+	t.mu.Unlock()
+	t.wg.Wait()
+	return err
+}
+
+func (t *Tunnel) serve() {
+	defer t.wg.Done()
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		if !t.track(conn) {
+			conn.Close()
+			return
+		}
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			defer t.untrack(conn)
+			if err := t.forward(conn); err != nil {
+				t.onError(fmt.Errorf("tunnel: %s: %w", t.Spec, err))
+			}
+		}()
+	}
+}
+
+// forward connects conn to target of tunnel, and copies data between them until either is closed.
+func (t *Tunnel) forward(conn net.Conn) error {
+	var (
+		target net.Conn
+		err    error
+	)
+	switch t.Spec.Kind {
+	case Local:
+		target, err = t.client.Dial("tcp", t.Spec.Target)
+	case Remote:
+		target, err = net.Dial("tcp", t.Spec.Target)
+	case Dynamic:
+		var addr string
+		if addr, err = socksRequest(conn); err != nil {
+			return err
+		}
+		if target, err = t.client.Dial("tcp", addr); err != nil {
+			socksReply(conn, socksHostUnreachable)
+			return fmt.Errorf("connect to %s failed: %w", addr, err)
+		}
+		err = socksReply(conn, socksSucceeded)
+	}
+	if err != nil {
+		return err
+	}
+	if !t.track(target) {
+		target.Close()
+		return nil
+	}
+	defer t.untrack(target)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(target, conn)
+		closeWrite(target)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(conn, target)
+		closeWrite(conn)
+	}()
+	wg.Wait()
+	return nil
+}
+
+// track records conn to close with tunnel, it reports false if tunnel is closed.
+func (t *Tunnel) track(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conns == nil {
+		return false
+	}
+	t.conns[conn] = struct{}{}
+	return true
+}
+
+func (t *Tunnel) untrack(conn net.Conn) {
+	conn.Close()
+	t.mu.Lock()
+	delete(t.conns, conn)
+	t.mu.Unlock()
+}
+
+// closeWrite half closes conn if supported, so peer sees EOF while remaining data is read.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}